@@ -0,0 +1,110 @@
+package rewrite
+
+import (
+	"fmt"
+	"github.com/html-overwrite/stream"
+	"io"
+	"strings"
+)
+
+// ApplyConfig is the options rules are applied with by Apply.
+type ApplyConfig struct {
+	// Engine is the engine rules are applied with when Force is set,
+	// otherwise the stream engine is picked when it can apply all of
+	// them and the lossless engine when it can't.
+	Engine Engine
+	Force  bool
+}
+
+// Report tells how rules were applied.
+type Report struct {
+	// Engine is the engine the rules were applied with.
+	Engine Engine
+	// Reason is why the engine was picked.
+	Reason string
+}
+
+// Apply applies the rules to the html read from r with the stream engine
+// when it can apply all of them, falling back to the lossless engine when it
+// can't, and writes the result to w. Either way the result is the same. Rules
+// that matched nothing are reported as ErrNotFound along with the report of
+// the engine that was used.
+func Apply(r io.Reader, w io.Writer, rules ...stream.Rule) (*Report, error) {
+	return ApplyConfig{}.Apply(r, w, rules...)
+}
+
+// Apply applies the rules like the package level Apply does,
+// using the engine of the config when it is forced.
+func (c ApplyConfig) Apply(r io.Reader, w io.Writer, rules ...stream.Rule) (*Report, error) {
+	report, err := c.choose(rules)
+	if err != nil {
+		return nil, err
+	}
+	return report, report.Engine.Rewrite(r, w, rules...)
+}
+
+// choose picks the engine the rules are applied with.
+func (c ApplyConfig) choose(rules []stream.Rule) (*Report, error) {
+	if c.Force {
+		for i, rule := range rules {
+			if err := c.Engine.supports(rule); err != nil {
+				return nil, fmt.Errorf("rule %d can't be applied by the %v engine: %w", i, c.Engine, err)
+			}
+		}
+		return &Report{Engine: c.Engine, Reason: "forced"}, nil
+	}
+
+	for i, rule := range rules {
+		err := StreamEngine.supports(rule)
+		if err == nil && !singleMatch(rule.Path) {
+			// the stream engine changes the first matching element
+			// where the lossless one changes all of them
+			err = fmt.Errorf("path %q can match several elements, the stream engine changes the first one", rule.Path)
+		}
+		if err == nil {
+			continue
+		}
+
+		// the rules left need to fit the lossless engine as well
+		for j, rule := range rules {
+			if losslessErr := LosslessEngine.supports(rule); losslessErr != nil {
+				return nil, fmt.Errorf("rules %d and %d can't be applied by the same engine: %v, %w", i, j, err, losslessErr)
+			}
+		}
+		return &Report{Engine: LosslessEngine, Reason: fmt.Sprintf("rule %d: %v", i, err)}, nil
+	}
+
+	return &Report{Engine: StreamEngine, Reason: "all rules can be streamed"}, nil
+}
+
+// singleMatch reports whether the path matches a single element, which
+// both engines change alike. Css paths are taken as such since only the
+// stream engine applies them.
+func singleMatch(path string) bool {
+	if strings.HasPrefix(path, "css=") {
+		return true
+	}
+	return strings.HasPrefix(path, "id=") && !strings.Contains(path, ",")
+}
+
+// supports returns why the engine can't apply the rule, nil if it can.
+func (e Engine) supports(rule stream.Rule) error {
+	css := strings.HasPrefix(rule.Path, "css=")
+
+	switch e {
+	case StreamEngine:
+	case StdEngine, LosslessEngine:
+		if css {
+			return fmt.Errorf("path %q is a css selector, which only the stream engine takes", rule.Path)
+		}
+		for _, matcher := range strings.Split(rule.Path, ",") {
+			if !strings.Contains(matcher, "=") {
+				return fmt.Errorf("matcher %q of path %q is not key=value", matcher, rule.Path)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown engine %v", e)
+	}
+
+	return nil
+}
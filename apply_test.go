@@ -0,0 +1,159 @@
+package rewrite
+
+import (
+	"bytes"
+	"errors"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	const page = `<html><head></head><body><div id="content"></div><p class="note">a</p><p class="note">b</p></body></html>`
+
+	t.Run("stream", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		report, err := Apply(strings.NewReader(page), out,
+			stream.SetRule("id=content", "text"),
+			stream.AttrRule("css=body > p.note", "title", "x"),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, &Report{Engine: StreamEngine, Reason: "all rules can be streamed"}, report)
+		assert.Equal(t, `<html><head></head><body><div id="content">text</div><p class="note" title="x">a</p><p class="note">b</p></body></html>`, out.String())
+	})
+
+	t.Run("lossless fallback", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		report, err := Apply(strings.NewReader(page), out,
+			stream.SetRule("id=content", "text"),
+			stream.SetRule("id=missing,class=note", "c"),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, LosslessEngine, report.Engine)
+		assert.Equal(t, `rule 1: path "id=missing,class=note" can match several elements, the stream engine changes the first one`, report.Reason)
+		assert.Equal(t, `<html><head></head><body><div id="content">text</div><p class="note">c</p><p class="note">c</p></body></html>`, out.String())
+	})
+
+	t.Run("several matches", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		report, err := Apply(strings.NewReader(page), out, stream.AttrRule("class=note", "title", "x"))
+		assert.Nil(t, err)
+		assert.Equal(t, LosslessEngine, report.Engine)
+		assert.Equal(t, `rule 0: path "class=note" can match several elements, the stream engine changes the first one`, report.Reason)
+		assert.Equal(t, `<html><head></head><body><div id="content"></div><p class="note" title="x">a</p><p class="note" title="x">b</p></body></html>`, out.String())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		report, err := Apply(strings.NewReader(page), &bytes.Buffer{}, stream.RemoveRule("id=missing"))
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Equal(t, StreamEngine, report.Engine)
+	})
+
+	t.Run("conflicting rules", func(t *testing.T) {
+		report, err := Apply(strings.NewReader(page), &bytes.Buffer{},
+			stream.SetRule("css=p", "c"),
+			stream.SetRule("id=content,class=note", "c"),
+		)
+		assert.Nil(t, report)
+		assert.EqualError(t, err, `rules 1 and 0 can't be applied by the same engine: `+
+			`path "id=content,class=note" can match several elements, the stream engine changes the first one, `+
+			`path "css=p" is a css selector, which only the stream engine takes`)
+	})
+
+	t.Run("css and several matches", func(t *testing.T) {
+		report, err := Apply(strings.NewReader(page), &bytes.Buffer{},
+			stream.SetRule("css=p", "c"),
+			stream.RemoveRule("tag=p"),
+		)
+		assert.Nil(t, report)
+		assert.EqualError(t, err, `rules 1 and 0 can't be applied by the same engine: `+
+			`path "tag=p" can match several elements, the stream engine changes the first one, `+
+			`path "css=p" is a css selector, which only the stream engine takes`)
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		for _, engine := range []Engine{StreamEngine, StdEngine, LosslessEngine} {
+			out := &bytes.Buffer{}
+			report, err := ApplyConfig{Engine: engine, Force: true}.Apply(strings.NewReader(page), out, stream.SetRule("class=note", "c"))
+			assert.Nil(t, err, engine.String())
+			assert.Equal(t, &Report{Engine: engine, Reason: "forced"}, report)
+			assert.Contains(t, out.String(), `<p class="note">c</p>`, engine.String())
+		}
+
+		_, err := ApplyConfig{Engine: StdEngine, Force: true}.Apply(strings.NewReader(page), &bytes.Buffer{}, stream.SetRule("css=p", "c"))
+		assert.EqualError(t, err, `rule 0 can't be applied by the std engine: path "css=p" is a css selector, which only the stream engine takes`)
+
+		out := &bytes.Buffer{}
+		_, err = ApplyConfig{Engine: StreamEngine, Force: true}.Apply(strings.NewReader(page), out, stream.SetRule("id=missing,class=note", "c"))
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), `<p class="note">c</p><p class="note">b</p>`)
+
+		_, err = ApplyConfig{Engine: StdEngine, Force: true}.Apply(strings.NewReader(page), &bytes.Buffer{}, stream.SetRule("id=a,b", "c"))
+		assert.EqualError(t, err, `rule 0 can't be applied by the std engine: matcher "b" of path "id=a,b" is not key=value`)
+
+		_, err = ApplyConfig{Engine: Engine(7), Force: true}.Apply(strings.NewReader(page), &bytes.Buffer{}, stream.SetRule("id=a", "c"))
+		assert.EqualError(t, err, `rule 0 can't be applied by the Engine(7) engine: unknown engine Engine(7)`)
+	})
+}
+
+func TestApplyEngines(t *testing.T) {
+	const body = `
+  <div id=content class='main wide'>
+    <p>first
+    <p id=second>second
+    <img id=logo src=logo.png>
+    <ul><li>a<li id=last>b</ul>
+  </div>
+  <div id="footer"/><span>footer</span></div>
+`
+	documents := map[string]string{
+		"document":   "<!DOCTYPE html>\n<html lang=en>\n<head><title>Page</title>\n<body>" + body,
+		"no html":    "<!DOCTYPE html>\n<body>" + body,
+		"no body":    "<!DOCTYPE html>" + body,
+		"table":      "<table><tr><td>" + body + "</table>",
+		"paragraphs": "<p>intro" + body,
+		"cdata":      "<div><![CDATA[ <p id=second>cdata ]]></div>" + body,
+	}
+
+	tests := []struct {
+		name    string
+		rules   []stream.Rule
+		engines []Engine
+	}{
+		{name: "set", rules: []stream.Rule{stream.SetRule("id=content", "text")}},
+		{name: "append", rules: []stream.Rule{stream.AppendRule("id=last", "<b>c</b>")}},
+		{name: "prepend", rules: []stream.Rule{stream.PrependRule("id=footer", "<i>f</i>")}},
+		{name: "remove", rules: []stream.Rule{stream.RemoveRule("id=second")}},
+		{name: "attribute", rules: []stream.Rule{stream.AttrRule("id=logo", "src", "new.png")}},
+		{name: "several rules", rules: []stream.Rule{
+			stream.AttrRule("id=content", "class", "narrow"),
+			stream.AppendRule("id=second", "<b>!</b>"),
+			stream.SetRule("id=footer", ""),
+		}},
+		// only the lossless engine changes every match
+		{name: "several matches", rules: []stream.Rule{stream.AttrRule("tag=p", "class", "x")}, engines: []Engine{LosslessEngine}},
+		{name: "several matchers", rules: []stream.Rule{stream.SetRule("tag=li,id=last", "c")}, engines: []Engine{LosslessEngine}},
+	}
+
+	for name, document := range documents {
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				applied := &bytes.Buffer{}
+				_, err := Apply(strings.NewReader(document), applied, test.rules...)
+				assert.Nil(t, err)
+				assert.NotEqual(t, document, applied.String())
+
+				engines := test.engines
+				if engines == nil {
+					engines = []Engine{StreamEngine, LosslessEngine}
+				}
+				for _, engine := range engines {
+					out := &bytes.Buffer{}
+					assert.Nil(t, engine.Rewrite(strings.NewReader(document), out, test.rules...), engine.String())
+					assert.Equal(t, applied.String(), out.String(), engine.String())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/html-overwrite/rulefile"
+)
+
+// applyCommand applies the rules of a rules
+// file to stdin or to a list of files.
+func applyCommand(env *environment, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	rf := &rewriteFlags{}
+	rf.register(flags)
+	rulesPath := flags.String("rules", "", "json or yaml file holding the rules")
+	flags.Usage = func() {
+		fmt.Fprintln(env.stderr, "usage: go-rewrite apply [flags] -rules file [files...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *rulesPath == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+
+	file, err := rulefile.Load(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	return rewriteFiles(env, opts, flags.Args(), file.StreamRules()...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "para.html", "<p>new</p>")
+	rules := writeFile(t, dir, "rules.yaml", `rules:
+  - selector: id=content
+    action: set
+    valueFile: para.html
+  - selector: id=banner
+    action: remove
+    onMissing: ignore
+  - selector: id=content
+    action: attr
+    attribute: class
+    value: main
+`)
+	expected := `<html><head></head><body><div id="content" class="main"><p>new</p></div></body></html>`
+
+	for _, engine := range []string{"stream", "std"} {
+		t.Run(engine, func(t *testing.T) {
+			stdout, stderr, err := runWith(testHTML, "apply", "-engine", engine, "-rules", rules)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, stdout)
+			assert.Empty(t, stderr)
+		})
+	}
+
+	t.Run("in place", func(t *testing.T) {
+		page := writeFile(t, dir, "page.html", testHTML)
+		_, _, err := runWith("", "apply", "-i", "-rules", rules, filepath.Join(dir, "page.html"))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, readFile(t, page))
+	})
+
+	t.Run("missing element", func(t *testing.T) {
+		missing := writeFile(t, dir, "missing.yaml", "rules:\n  - {selector: id=banner, action: remove, onMissing: error}\n")
+		page := writeFile(t, dir, "missing.html", testHTML)
+
+		_, _, err := runWith("", "apply", "-i", "-rules", missing, page)
+		assert.True(t, errors.Is(err, stream.ErrNotFound))
+		assert.Equal(t, 1, exitCode(err))
+		assert.Equal(t, testHTML, readFile(t, page))
+
+		_, stderr, err := runWith(testHTML, "apply", "-allow-missing", "-rules", missing)
+		assert.Nil(t, err)
+		assert.Contains(t, stderr, `element matching "id=banner" was not found`)
+	})
+
+	t.Run("missing rules flag", func(t *testing.T) {
+		_, _, err := runWith(testHTML, "apply")
+		assert.Equal(t, errUsage, err)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		invalid := writeFile(t, dir, "invalid.json", `{"rules": [{"action": "remove"}]}`)
+		_, _, err := runWith(testHTML, "apply", "-rules", invalid)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "rule 1")
+		}
+	})
+}
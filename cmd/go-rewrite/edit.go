@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	rewrite "github.com/html-overwrite"
+	"github.com/html-overwrite/diff"
+	"github.com/html-overwrite/stream"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// edit describes the arguments of an editing command
+// and how they are turned into a rule.
+type edit struct {
+	name string
+	args []string
+	rule func(args []string) stream.Rule
+}
+
+var (
+	setEdit = edit{"set", []string{"path", "value"}, func(args []string) stream.Rule {
+		return stream.SetRule(args[0], args[1])
+	}}
+	appendEdit = edit{"append", []string{"path", "value"}, func(args []string) stream.Rule {
+		return stream.AppendRule(args[0], args[1])
+	}}
+	prependEdit = edit{"prepend", []string{"path", "value"}, func(args []string) stream.Rule {
+		return stream.PrependRule(args[0], args[1])
+	}}
+	removeEdit = edit{"remove", []string{"path"}, func(args []string) stream.Rule {
+		return stream.RemoveRule(args[0])
+	}}
+	attrEdit = edit{"attr", []string{"path", "name", "value"}, func(args []string) stream.Rule {
+		return stream.AttrRule(args[0], args[1], args[2])
+	}}
+)
+
+// output is where the rewritten documents go.
+type output uint8
+
+const (
+	// stdoutOutput writes the documents to stdout.
+	stdoutOutput output = iota
+	// inPlaceOutput replaces the files with their rewritten form.
+	inPlaceOutput
+	// diffOutput writes a unified diff of the changes to stdout.
+	diffOutput
+	// editsOutput writes the edits to stdout as json lines.
+	editsOutput
+)
+
+// rewriteFlags are the flags shared by the commands rewriting files.
+type rewriteFlags struct {
+	inPlace      bool
+	diff         bool
+	edits        bool
+	engine       string
+	allowMissing bool
+}
+
+func (f *rewriteFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&f.inPlace, "i", false, "edit the files in place")
+	flags.BoolVar(&f.diff, "diff", false, "print a unified diff of the changes without writing them")
+	flags.BoolVar(&f.edits, "edits", false, "print the edits as json lines without writing them")
+	flags.StringVar(&f.engine, "engine", "stream", "engine used for rewriting, one of stream, std and lossless")
+	flags.BoolVar(&f.allowMissing, "allow-missing", false, "only warn about rules matching nothing instead of failing")
+}
+
+// options resolves the parsed flags into the rewrite options.
+func (f *rewriteFlags) options() (rewriteOptions, error) {
+	opts := rewriteOptions{allowMissing: f.allowMissing}
+
+	var err error
+	if opts.engine, err = engineByName(f.engine); err != nil {
+		return opts, err
+	}
+
+	selected := 0
+	for out, set := range map[output]bool{inPlaceOutput: f.inPlace, diffOutput: f.diff, editsOutput: f.edits} {
+		if set {
+			opts.output = out
+			selected++
+		}
+	}
+	if selected > 1 {
+		return opts, errors.New("-i, -diff and -edits are mutually exclusive")
+	}
+
+	return opts, nil
+}
+
+// rewriteOptions control how files are rewritten.
+type rewriteOptions struct {
+	engine       rewrite.Engine
+	output       output
+	allowMissing bool
+}
+
+// editCommand creates a command applying the given
+// edit to stdin or to a list of files.
+func editCommand(e edit) command {
+	return func(env *environment, args []string) error {
+		flags := flag.NewFlagSet(e.name, flag.ContinueOnError)
+		flags.SetOutput(env.stderr)
+		rf := &rewriteFlags{}
+		rf.register(flags)
+		flags.Usage = func() {
+			fmt.Fprintf(env.stderr, "usage: go-rewrite %s [flags] %s [files...]\n", e.name, strings.Join(e.args, " "))
+			flags.PrintDefaults()
+		}
+
+		if err := flags.Parse(args); err != nil {
+			return errUsage
+		}
+
+		if flags.NArg() < len(e.args) {
+			flags.Usage()
+			return errUsage
+		}
+
+		opts, err := rf.options()
+		if err != nil {
+			return err
+		}
+
+		rule := e.rule(flags.Args()[:len(e.args)])
+		if err := rule.Validate(); err != nil {
+			fmt.Fprintf(env.stderr, "go-rewrite %s: %v\n", e.name, err)
+			flags.Usage()
+			return errUsage
+		}
+
+		return rewriteFiles(env, opts, flags.Args()[len(e.args):], rule)
+	}
+}
+
+// rewriteFiles applies the rules to each of the files matching the
+// given patterns, or to stdin when none are given. the output is
+// written to stdout unless the files are edited in place.
+func rewriteFiles(env *environment, opts rewriteOptions, patterns []string, rules ...stream.Rule) error {
+	// invalid rules would fail on every file, or
+	// worse, break the engines they are handed to
+	if err := stream.ValidateRules(rules...); err != nil {
+		return err
+	}
+
+	if len(patterns) == 0 {
+		if opts.output == inPlaceOutput {
+			return errors.New("in place editing requires files")
+		}
+		return rewriteOutput(env, "stdin", opts, env.stdin, env.stdout, rules)
+	}
+
+	files, err := expandFiles(patterns)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if opts.output == inPlaceOutput {
+			err = rewriteInPlace(env, opts, file, rules)
+		} else {
+			err = rewriteToStdout(env, opts, file, rules)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteOutput rewrites r into w. Rules that matched nothing fail
+// it, after the document was written, unless they are optional or
+// missing rules are allowed, in which case they are only reported.
+func rewriteOutput(env *environment, name string, opts rewriteOptions, r io.Reader, w io.Writer, rules []stream.Rule) error {
+	var err error
+	switch opts.output {
+	case diffOutput, editsOutput:
+		err = dryRun(name, opts, r, w, rules)
+	default:
+		err = opts.engine.Rewrite(r, w, rules...)
+	}
+
+	if errors.Is(err, stream.ErrNotFound) && opts.allowMissing {
+		env.warn("%s: %v", name, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// editLine is a line of the edits output.
+type editLine struct {
+	File string `json:"file"`
+	diff.Edit
+}
+
+// dryRun writes the changes the rules would make to r into w.
+func dryRun(name string, opts rewriteOptions, r io.Reader, w io.Writer, rules []stream.Rule) error {
+	changes, err := rewrite.DryRun(r, opts.engine, rules...)
+	if changes == nil {
+		return err
+	}
+
+	if opts.output == diffOutput {
+		if _, werr := io.WriteString(w, changes.Unified(name)); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, edit := range changes.Edits() {
+		if werr := encoder.Encode(editLine{File: name, Edit: edit}); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+func rewriteToStdout(env *environment, opts rewriteOptions, file string, rules []stream.Rule) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rewriteOutput(env, file, opts, f, env.stdout, rules)
+}
+
+// rewriteInPlace rewrites the file into itself.
+func rewriteInPlace(env *environment, opts rewriteOptions, file string, rules []stream.Rule) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(file, info.Mode(), func(w io.Writer) error {
+		return rewriteOutput(env, file, opts, f, w, rules)
+	})
+}
+
+// replaceFile writes a temporary file next to target
+// which then replaces it, target is left intact when
+// writing fails.
+func replaceFile(target string, mode os.FileMode, write func(w io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+// expandFiles expands the glob patterns into file names,
+// patterns matching nothing are kept as is so opening
+// them reports the missing file.
+func expandFiles(patterns []string) ([]string, error) {
+	files := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{pattern}
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
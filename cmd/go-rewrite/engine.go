@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	rewrite "github.com/html-overwrite"
+)
+
+func engineByName(name string) (rewrite.Engine, error) {
+	for _, engine := range []rewrite.Engine{rewrite.StreamEngine, rewrite.StdEngine, rewrite.LosslessEngine} {
+		if engine.String() == name {
+			return engine, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine %q", name)
+}
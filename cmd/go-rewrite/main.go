@@ -0,0 +1,127 @@
+// Command go-rewrite applies html modifications to files
+// or to stdin, meant to be used from build scripts.
+//
+// Usage:
+//
+//	go-rewrite set [flags] path value [files...]
+//	go-rewrite append [flags] path value [files...]
+//	go-rewrite prepend [flags] path value [files...]
+//	go-rewrite remove [flags] path [files...]
+//	go-rewrite attr [flags] path name value [files...]
+//	go-rewrite apply [flags] -rules file [files...]
+//	go-rewrite watch [flags] -rules file -src dir -out dir
+//	go-rewrite proxy [flags] -upstream url -rules file
+//
+// When no files are given the html is read from stdin and
+// written to stdout. Files may be given as glob patterns.
+// The -diff and -edits flags print the changes that would
+// be made, as a unified diff or as json lines, instead.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+// errUsage is returned when the command line is
+// invalid, the usage was already printed by then.
+var errUsage = errors.New("invalid usage")
+
+// command runs a subcommand with the
+// arguments following its name.
+type command func(env *environment, args []string) error
+
+// environment holds the standard streams
+// the commands work with.
+type environment struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// done is closed once the long running
+	// commands are asked to stop.
+	done <-chan struct{}
+}
+
+// warn reports a problem that doesn't fail the command.
+func (env *environment) warn(format string, args ...interface{}) {
+	fmt.Fprintf(env.stderr, "go-rewrite: "+format+"\n", args...)
+}
+
+var commands = map[string]command{
+	"set":     editCommand(setEdit),
+	"append":  editCommand(appendEdit),
+	"prepend": editCommand(prependEdit),
+	"remove":  editCommand(removeEdit),
+	"attr":    editCommand(attrEdit),
+	"apply":   applyCommand,
+	"watch":   watchCommand,
+	"proxy":   proxyCommand,
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: go-rewrite <command> [flags] [arguments]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+	fmt.Fprintln(w, "run 'go-rewrite <command> -h' for the usage of a command")
+}
+
+func run(env *environment, args []string) error {
+	if len(args) == 0 {
+		usage(env.stderr)
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(env.stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return cmd(env, args[1:])
+}
+
+func main() {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// a second signal kills the process
+		signal.Stop(signals)
+		close(done)
+	}()
+
+	env := &environment{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, done: done}
+
+	err := run(env, os.Args[1:])
+	if err != nil && err != errUsage {
+		env.warn("%v", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode is the status the command exits with
+// after it returned the given error.
+func exitCode(err error) int {
+	switch err {
+	case nil:
+		return 0
+	case errUsage:
+		return 2
+	default:
+		return 1
+	}
+}
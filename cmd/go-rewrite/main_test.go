@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHTML = `<html><head></head><body><div id="content"><p>old</p></div></body></html>`
+
+// runWith runs the command line with the given stdin and
+// returns what was written to stdout and stderr.
+func runWith(stdin string, args ...string) (string, string, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	env := &environment{stdin: strings.NewReader(stdin), stdout: stdout, stderr: stderr}
+	err := run(env, args)
+	return stdout.String(), stderr.String(), err
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0640))
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	return string(content)
+}
+
+func TestEditCommands(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{
+			args:     []string{"set", "id=content", "<p>new</p>"},
+			expected: `<html><head></head><body><div id="content"><p>new</p></div></body></html>`,
+		},
+		{
+			args:     []string{"append", "id=content", "<p>new</p>"},
+			expected: `<html><head></head><body><div id="content"><p>old</p><p>new</p></div></body></html>`,
+		},
+		{
+			args:     []string{"prepend", "id=content", "<p>new</p>"},
+			expected: `<html><head></head><body><div id="content"><p>new</p><p>old</p></div></body></html>`,
+		},
+		{
+			args:     []string{"remove", "id=content"},
+			expected: `<html><head></head><body></body></html>`,
+		},
+		{
+			args:     []string{"attr", "id=content", "class", "main"},
+			expected: `<html><head></head><body><div id="content" class="main"><p>old</p></div></body></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.args[0], func(t *testing.T) {
+			t.Run("stream", func(t *testing.T) {
+				stdout, _, err := runWith(testHTML, test.args...)
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, stdout)
+			})
+
+			t.Run("std", func(t *testing.T) {
+				args := append([]string{test.args[0], "-engine", "std"}, test.args[1:]...)
+				stdout, _, err := runWith(testHTML, args...)
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, stdout)
+			})
+		})
+	}
+}
+
+func TestLosslessEngine(t *testing.T) {
+	const page = "<!doctype html>\n<div id=content class=main>\n  <p>old\n</div>\n"
+
+	stdout, _, err := runWith(page, "set", "-engine", "lossless", "id=content", "new")
+	assert.Nil(t, err)
+	assert.Equal(t, "<!doctype html>\n<div id=content class=main>new</div>\n", stdout)
+}
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	first := writeFile(t, dir, "first.html", testHTML)
+	second := writeFile(t, dir, "second.html", testHTML)
+	other := writeFile(t, dir, "other.txt", testHTML)
+
+	_, _, err := runWith("", "set", "-i", "id=content", "<p>new</p>", filepath.Join(dir, "*.html"))
+	assert.Nil(t, err)
+
+	expected := `<html><head></head><body><div id="content"><p>new</p></div></body></html>`
+	assert.Equal(t, expected, readFile(t, first))
+	assert.Equal(t, expected, readFile(t, second))
+	assert.Equal(t, testHTML, readFile(t, other))
+
+	info, err := os.Stat(first)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestFilesToStdout(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "page.html", testHTML)
+
+	stdout, _, err := runWith("", "remove", "id=content", file, file)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat(`<html><head></head><body></body></html>`, 2), stdout)
+	assert.Equal(t, testHTML, readFile(t, file))
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "page.html", testHTML)
+
+	t.Run("diff", func(t *testing.T) {
+		stdout, _, err := runWith("", "set", "-diff", "id=content", "<p>new</p>", file)
+		assert.Nil(t, err)
+		assert.Equal(t, "--- a/"+file+"\n+++ b/"+file+"\n@@ -1 +1 @@\n-"+testHTML+
+			"\n\\ No newline at end of file\n+"+strings.Replace(testHTML, "old", "new", 1)+
+			"\n\\ No newline at end of file\n", stdout)
+		assert.Equal(t, testHTML, readFile(t, file))
+	})
+
+	t.Run("edits", func(t *testing.T) {
+		stdout, _, err := runWith(testHTML, "attr", "-edits", "id=content", "class", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, `{"file":"stdin","offset":42,"line":1,"column":43,"old":"","new":" class=\"main\""}`+"\n", stdout)
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		stdout, stderr, err := runWith(testHTML, "remove", "-diff", "-allow-missing", "id=missing")
+		assert.Nil(t, err)
+		assert.Empty(t, stdout)
+		assert.Contains(t, stderr, "not found")
+
+		stdout, _, err = runWith(testHTML, "remove", "-diff", "id=missing")
+		assert.True(t, errors.Is(err, stream.ErrNotFound))
+		assert.Empty(t, stdout)
+	})
+
+	t.Run("exclusive", func(t *testing.T) {
+		_, _, err := runWith("", "remove", "-i", "-diff", "id=content", file)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCommandErrors(t *testing.T) {
+	t.Run("no command", func(t *testing.T) {
+		_, stderr, err := runWith(testHTML)
+		assert.Equal(t, errUsage, err)
+		assert.Contains(t, stderr, "usage")
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		_, _, err := runWith(testHTML, "replace")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("missing arguments", func(t *testing.T) {
+		_, _, err := runWith(testHTML, "attr", "id=content", "class")
+		assert.Equal(t, errUsage, err)
+		assert.Equal(t, 2, exitCode(err))
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		for _, engine := range []string{"stream", "std", "lossless"} {
+			stdout, stderr, err := runWith(testHTML, "set", "-engine", engine, "content", "<p>x</p>")
+			assert.Equal(t, errUsage, err)
+			assert.Empty(t, stdout)
+			assert.Contains(t, stderr, `go-rewrite set: invalid path "content"`)
+			assert.Contains(t, stderr, "usage")
+
+			_, _, err = runWith(testHTML, "remove", "-engine", engine, "id=content,main")
+			assert.Equal(t, errUsage, err)
+		}
+	})
+
+	t.Run("unknown engine", func(t *testing.T) {
+		_, _, err := runWith(testHTML, "remove", "-engine", "regex", "id=content")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("in place without files", func(t *testing.T) {
+		_, _, err := runWith(testHTML, "remove", "-i", "id=content")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, _, err := runWith("", "remove", "id=content", filepath.Join(t.TempDir(), "missing.html"))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		stdout, _, err := runWith(testHTML, "remove", "id=missing")
+		assert.True(t, errors.Is(err, stream.ErrNotFound))
+		assert.Equal(t, 1, exitCode(err))
+		assert.EqualError(t, err, `stdin: element matching "id=missing" was not found: no matching element`)
+		assert.Equal(t, testHTML, stdout)
+
+		dir := t.TempDir()
+		page := writeFile(t, dir, "page.html", testHTML)
+		_, _, err = runWith("", "set", "-i", "id=missing", "x", page)
+		assert.True(t, errors.Is(err, stream.ErrNotFound))
+		assert.Equal(t, testHTML, readFile(t, page))
+	})
+
+	t.Run("allowed missing match", func(t *testing.T) {
+		stdout, stderr, err := runWith(testHTML, "remove", "-allow-missing", "id=missing")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, exitCode(err))
+		assert.Equal(t, testHTML, stdout)
+		assert.Contains(t, stderr, "not found")
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/html-overwrite/httpmw"
+	"github.com/html-overwrite/rulefile"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// shutdownTimeout is how long the in flight
+// requests are waited for when stopping.
+const shutdownTimeout = 5 * time.Second
+
+// proxyCommand serves the upstream through a reverse
+// proxy rewriting its html until it is interrupted.
+func proxyCommand(env *environment, args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	upstream := flags.String("upstream", "", "url of the server the requests are forwarded to")
+	rulesPath := flags.String("rules", "", "json or yaml file holding the rules")
+	listen := flags.String("listen", ":8080", "address to listen on")
+	flags.Usage = func() {
+		fmt.Fprintln(env.stderr, "usage: go-rewrite proxy [flags] -upstream url -rules file")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *upstream == "" || *rulesPath == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	target, err := url.Parse(*upstream)
+	if err != nil {
+		return err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("invalid upstream %q, expected an http or https url", *upstream)
+	}
+
+	file, err := rulefile.Load(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		// the upstream may serve several hosts
+		req.Host = target.Host
+	}
+	proxy.ModifyResponse = httpmw.ModifyResponse(file.StreamRules()...)
+	proxy.ErrorLog = log.New(env.stderr, "go-rewrite: ", 0)
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: proxy, ErrorLog: proxy.ErrorLog}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	fmt.Fprintf(env.stdout, "proxying %s on http://%s\n", target, listener.Addr())
+
+	select {
+	case err = <-served:
+		return err
+	case <-env.done:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err = <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	var hosts []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		switch r.URL.Path {
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"content"}`))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(testHTML))
+		}
+	}))
+	defer upstream.Close()
+
+	rules := writeFile(t, t.TempDir(), "rules.json",
+		`{"rules": [{"selector": "tag=body", "action": "prepend", "value": "<div>staging</div>"}]}`)
+
+	done := make(chan struct{})
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	env := &environment{stdin: strings.NewReader(""), stdout: stdout, stderr: stderr, done: done}
+
+	result := make(chan error)
+	go func() {
+		result <- run(env, []string{"proxy", "-upstream", upstream.URL, "-rules", rules, "-listen", "127.0.0.1:0"})
+	}()
+
+	address := regexp.MustCompile(`on (http://\S+)`)
+	assert.Eventually(t, func() bool {
+		return address.MatchString(stdout.String())
+	}, 5*time.Second, 5*time.Millisecond, stderr.String())
+	proxy := address.FindStringSubmatch(stdout.String())[1]
+
+	get := func(path string) string {
+		res, err := http.Get(proxy + path)
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		return string(body)
+	}
+
+	assert.Equal(t, strings.Replace(testHTML, "<body>", "<body><div>staging</div>", 1), get("/"))
+	assert.Equal(t, `{"id":"content"}`, get("/data.json"))
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), hosts[0])
+
+	close(done)
+	assert.Nil(t, <-result)
+}
+
+func TestProxyErrors(t *testing.T) {
+	rules := writeFile(t, t.TempDir(), "rules.yaml", "rules: []\n")
+
+	_, _, err := runWith("", "proxy", "-rules", rules)
+	assert.Equal(t, errUsage, err)
+
+	_, _, err = runWith("", "proxy", "-upstream", "localhost:3000", "-rules", rules)
+	assert.NotNil(t, err)
+
+	_, _, err = runWith("", "proxy", "-upstream", "http://localhost:3000", "-rules", rules, "-listen", "invalid:address:0")
+	assert.NotNil(t, err)
+}
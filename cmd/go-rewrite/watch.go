@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	rewrite "github.com/html-overwrite"
+	"github.com/html-overwrite/rulefile"
+	"github.com/html-overwrite/stream"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// watchCommand rewrites a directory into another one and keeps
+// rewriting the files that change until it is interrupted.
+func watchCommand(env *environment, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	rulesPath := flags.String("rules", "", "json or yaml file holding the rules")
+	src := flags.String("src", "", "directory holding the source files")
+	out := flags.String("out", "", "directory the rewritten files are written to")
+	engineName := flags.String("engine", "stream", "engine used for rewriting, one of stream, std and lossless")
+	interval := flags.Duration("interval", 500*time.Millisecond, "interval between checks for changes")
+	debounce := flags.Duration("debounce", 200*time.Millisecond, "time without changes waited for before rewriting")
+	flags.Usage = func() {
+		fmt.Fprintln(env.stderr, "usage: go-rewrite watch [flags] -rules file -src dir -out dir")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *rulesPath == "" || *src == "" || *out == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	engine, err := engineByName(*engineName)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{
+		env:       env,
+		engine:    engine,
+		rulesPath: *rulesPath,
+		src:       *src,
+		out:       *out,
+		watched:   map[string]fileState{},
+		files:     map[string]fileState{},
+	}
+	if err = w.loadRules(); err != nil {
+		return err
+	}
+
+	return w.run(*interval, *debounce)
+}
+
+// fileState is what changes of a file are detected by.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    fs.FileMode
+}
+
+func stateOf(info fs.FileInfo) fileState {
+	return fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+}
+
+// watcher polls the source directory, the rules file and the files
+// holding the values of its rules, rewriting the files affected by
+// their changes.
+type watcher struct {
+	env       *environment
+	engine    rewrite.Engine
+	rulesPath string
+	src       string
+	out       string
+
+	rules   []stream.Rule
+	watched map[string]fileState // the rules file and its value files
+	files   map[string]fileState // by path relative to src
+}
+
+// loadRules loads the rules file, keeping the current rules
+// when it is invalid. The value files of the loaded rules are
+// watched along with the rules file.
+func (w *watcher) loadRules() error {
+	info, err := os.Stat(w.rulesPath)
+	if err != nil {
+		return err
+	}
+	w.watched[w.rulesPath] = stateOf(info)
+
+	file, err := rulefile.Load(w.rulesPath)
+	if err != nil {
+		return err
+	}
+
+	watched := map[string]fileState{w.rulesPath: stateOf(info)}
+	for _, path := range file.ValueFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		watched[path] = stateOf(info)
+	}
+
+	w.rules, w.watched = file.StreamRules(), watched
+	return nil
+}
+
+// rulesChanged reports whether any of the watched rules
+// files changed since the previous check, files that
+// can't be read count as changed once.
+func (w *watcher) rulesChanged() bool {
+	changed := false
+	for path, state := range w.watched {
+		var current fileState
+		if info, err := os.Stat(path); err == nil {
+			current = stateOf(info)
+		}
+		if current != state {
+			w.watched[path] = current
+			changed = true
+		}
+	}
+	return changed
+}
+
+// run rewrites every file and then keeps polling for changes,
+// which are handled once none were seen for the debounce time.
+func (w *watcher) run(interval, debounce time.Duration) error {
+	changed, removed, err := w.scan()
+	if err != nil {
+		return err
+	}
+	w.update(changed, removed)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := map[string]bool{}
+	rulesChanged := false
+	var lastChange time.Time
+	for {
+		select {
+		case <-w.env.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		if w.rulesChanged() {
+			rulesChanged = true
+			lastChange = time.Now()
+		}
+
+		changed, removed, err := w.scan()
+		if err != nil {
+			w.env.warn("%v", err)
+			continue
+		}
+		for _, name := range changed {
+			pending[name] = true
+		}
+		for _, name := range removed {
+			pending[name] = false
+		}
+		if len(changed) > 0 || len(removed) > 0 {
+			lastChange = time.Now()
+		}
+
+		if (len(pending) == 0 && !rulesChanged) || time.Since(lastChange) < debounce {
+			continue
+		}
+
+		if rulesChanged {
+			rulesChanged = false
+			if err := w.loadRules(); err != nil {
+				w.env.warn("%v", err)
+			} else {
+				// every html file is affected by the rules
+				for name := range w.files {
+					if _, ok := pending[name]; !ok && rewrite.IsHTMLFile(name) {
+						pending[name] = true
+					}
+				}
+			}
+		}
+
+		changed, removed = changed[:0], removed[:0]
+		for name, exists := range pending {
+			if exists {
+				changed = append(changed, name)
+			} else {
+				removed = append(removed, name)
+			}
+			delete(pending, name)
+		}
+		w.update(changed, removed)
+	}
+}
+
+// scan walks the source directory and returns the files
+// that changed or were removed since the previous scan.
+func (w *watcher) scan() (changed, removed []string, err error) {
+	out, err := filepath.Abs(w.out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[string]bool{}
+	err = filepath.WalkDir(w.src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// the output may be part of the source
+			if abs, err := filepath.Abs(path); err == nil && abs == out {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(w.src, path)
+		if err != nil {
+			return err
+		}
+
+		seen[name] = true
+		if state, ok := w.files[name]; !ok || state != stateOf(info) {
+			w.files[name] = stateOf(info)
+			changed = append(changed, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name := range w.files {
+		if !seen[name] {
+			delete(w.files, name)
+			removed = append(removed, name)
+		}
+	}
+
+	return changed, removed, nil
+}
+
+// update brings the output of the given files up to date,
+// reporting the files that failed without stopping.
+func (w *watcher) update(changed, removed []string) {
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	updated := 0
+	for _, name := range changed {
+		if err := w.write(name); err != nil {
+			w.env.warn("%s: %v", filepath.Join(w.src, name), err)
+			continue
+		}
+		updated++
+	}
+
+	for _, name := range removed {
+		err := os.Remove(filepath.Join(w.out, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			w.env.warn("%v", err)
+			continue
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		fmt.Fprintf(w.env.stdout, "updated %d files\n", updated)
+	}
+}
+
+// write rewrites the source file with the given name into the
+// output directory, files other than html are copied as is.
+func (w *watcher) write(name string) error {
+	path := filepath.Join(w.src, name)
+	target := filepath.Join(w.out, name)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	return replaceFile(target, info.Mode(), func(out io.Writer) error {
+		stats, err := w.engine.RewriteFile(os.DirFS(w.src), filepath.ToSlash(name), out, w.rules...)
+		if stats != nil {
+			w.warnMissing(path, stats.Missing)
+		}
+		return err
+	})
+}
+
+// warnMissing warns about the rules that matched nothing in the file
+// at path, except for the optional ones. missing holds the paths of
+// those rules in the order of the rules.
+func (w *watcher) warnMissing(path string, missing []string) {
+	for _, rule := range w.rules {
+		if len(missing) == 0 {
+			return
+		}
+		if rule.Path != missing[0] {
+			continue
+		}
+		if !rule.Optional {
+			w.env.warn("%s: element matching %q was not found", path, rule.Path)
+		}
+		missing = missing[1:]
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer safe to use while the
+// command writes to it from another goroutine.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	src, out := filepath.Join(dir, "public"), filepath.Join(dir, "public", "dist")
+	assert.Nil(t, os.MkdirAll(filepath.Join(src, "blog"), 0755))
+
+	rules := writeFile(t, dir, "rules.yaml", "rules:\n  - {selector: id=content, action: set, value: first}\n")
+	writeFile(t, src, "index.html", testHTML)
+	writeFile(t, src, "blog/post.html", `<html><body></body></html>`)
+	writeFile(t, src, "site.css", `#content {}`)
+
+	done := make(chan struct{})
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	env := &environment{stdin: strings.NewReader(""), stdout: stdout, stderr: stderr, done: done}
+
+	result := make(chan error)
+	go func() {
+		result <- run(env, []string{"watch", "-rules", rules, "-src", src, "-out", out, "-interval", "5ms", "-debounce", "10ms"})
+	}()
+
+	read := func(name string) string {
+		content, _ := os.ReadFile(filepath.Join(out, name))
+		return string(content)
+	}
+	eventually := func(name, expected string) {
+		t.Helper()
+		assert.Eventually(t, func() bool { return read(name) == expected }, 5*time.Second, 5*time.Millisecond,
+			"%s: %q, stderr: %s", name, read(name), stderr.String())
+	}
+
+	page := func(content string) string {
+		return strings.Replace(testHTML, "<p>old</p>", content, 1)
+	}
+
+	eventually("index.html", page("first"))
+	eventually("site.css", `#content {}`)
+	eventually("blog/post.html", `<html><body></body></html>`)
+	assert.Contains(t, stderr.String(), "not found")
+
+	t.Run("source change", func(t *testing.T) {
+		writeFile(t, src, "blog/post.html", `<html><body><div id="content"></div></body></html>`)
+		eventually("blog/post.html", `<html><body><div id="content">first</div></body></html>`)
+	})
+
+	t.Run("rules change", func(t *testing.T) {
+		writeFile(t, dir, "rules.yaml", "rules:\n  - {selector: id=content, action: set, value: second}\n")
+		eventually("index.html", page("second"))
+		eventually("blog/post.html", `<html><body><div id="content">second</div></body></html>`)
+	})
+
+	t.Run("value file change", func(t *testing.T) {
+		writeFile(t, dir, "snippet.html", "<b>third</b>")
+		writeFile(t, dir, "rules.yaml", "rules:\n  - {selector: id=content, action: set, valueFile: snippet.html}\n")
+		eventually("index.html", page("<b>third</b>"))
+
+		writeFile(t, dir, "snippet.html", "<b>fourth</b>")
+		eventually("index.html", page("<b>fourth</b>"))
+		eventually("blog/post.html", `<html><body><div id="content"><b>fourth</b></div></body></html>`)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		writeFile(t, dir, "rules.yaml", "rules:\n  - {selector: id=content, action: replace}\n")
+		assert.Eventually(t, func() bool {
+			return strings.Contains(stderr.String(), `unknown action "replace"`)
+		}, 5*time.Second, 5*time.Millisecond)
+
+		writeFile(t, src, "index.html", testHTML+"\n")
+		eventually("index.html", page("<b>fourth</b>")+"\n")
+	})
+
+	t.Run("broken file", func(t *testing.T) {
+		writeFile(t, src, "broken.html", `<html><body><div id="content">`)
+		assert.Eventually(t, func() bool {
+			return strings.Contains(stderr.String(), "broken.html")
+		}, 5*time.Second, 5*time.Millisecond)
+		_, err := os.Stat(filepath.Join(out, "broken.html"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("removal", func(t *testing.T) {
+		assert.Nil(t, os.Remove(filepath.Join(src, "site.css")))
+		assert.Eventually(t, func() bool {
+			_, err := os.Stat(filepath.Join(out, "site.css"))
+			return os.IsNotExist(err)
+		}, 5*time.Second, 5*time.Millisecond)
+	})
+
+	close(done)
+	assert.Nil(t, <-result)
+	assert.Contains(t, stdout.String(), "updated")
+}
+
+func TestWatchErrors(t *testing.T) {
+	_, _, err := runWith("", "watch", "-src", ".", "-out", "dist")
+	assert.Equal(t, errUsage, err)
+
+	_, _, err = runWith("", "watch", "-rules", filepath.Join(t.TempDir(), "missing.yaml"), "-src", ".", "-out", "dist")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,170 @@
+package diff
+
+// chunk is a range of the original units along with
+// the range of the rewritten units it corresponds to.
+type chunk struct {
+	a0, a1  int
+	b0, b1  int
+	changed bool
+}
+
+// compare splits a and b into alternating unchanged
+// and changed chunks using the Myers algorithm.
+func compare(a, b []string) []chunk {
+	d := &differ{
+		a:        a,
+		b:        b,
+		aChanged: make([]bool, len(a)),
+		bChanged: make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	var chunks []chunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		c := chunk{a0: i, b0: j}
+		for i < len(a) && j < len(b) && !d.aChanged[i] && !d.bChanged[j] {
+			i++
+			j++
+		}
+		if i == c.a0 {
+			c.changed = true
+			for i < len(a) && d.aChanged[i] {
+				i++
+			}
+			for j < len(b) && d.bChanged[j] {
+				j++
+			}
+		}
+		c.a1, c.b1 = i, j
+		chunks = append(chunks, c)
+	}
+
+	return chunks
+}
+
+// differ marks the units of a and b that aren't
+// part of their longest common subsequence.
+type differ struct {
+	a, b               []string
+	aChanged, bChanged []bool
+}
+
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+
+	switch {
+	case a0 == a1:
+		for ; b0 < b1; b0++ {
+			d.bChanged[b0] = true
+		}
+	case b0 == b1:
+		for ; a0 < a1; a0++ {
+			d.aChanged[a0] = true
+		}
+	default:
+		x, y, ok := d.bisect(a0, a1, b0, b1)
+		if !ok {
+			for i := a0; i < a1; i++ {
+				d.aChanged[i] = true
+			}
+			for j := b0; j < b1; j++ {
+				d.bChanged[j] = true
+			}
+			return
+		}
+		d.compare(a0, x, b0, y)
+		d.compare(x, a1, y, b1)
+	}
+}
+
+// bisect finds the point where the forward and reverse paths
+// of the shortest edit script overlap, which splits it in two.
+// ok is false when a and b have nothing in common.
+func (d *differ) bisect(a0, a1, b0, b1 int) (x, y int, ok bool) {
+	a, b := d.a[a0:a1], d.b[b0:b1]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	v1 := make([]int, 2*maxD+2)
+	v2 := make([]int, 2*maxD+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+
+	delta := n - m
+	// the paths overlap in the forward pass when delta is odd
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for D := 0; D < maxD; D++ {
+		for k1 := -D + k1start; k1 <= D-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -D || (k1 != D && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < len(v2) && v2[k2Offset] != -1 && x1 >= n-v2[k2Offset] {
+					return a0 + x1, b0 + y1, true
+				}
+			}
+		}
+
+		for k2 := -D + k2start; k2 <= D-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -D || (k2 != D && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < len(v1) && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := offset + x1 - k1Offset
+					if x1 >= n-x2 {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
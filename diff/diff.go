@@ -0,0 +1,218 @@
+// Package diff compares an html document with its rewritten
+// form, either as a unified diff or as a list of edits.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the amount of unchanged lines
+// shown around the changes of a unified diff.
+const contextLines = 3
+
+// Edit is a change turning the original
+// text into the rewritten one.
+type Edit struct {
+	// Offset is the byte offset of the change in the original.
+	Offset int `json:"offset"`
+	// Line of Offset in the original, starting at 1.
+	Line int `json:"line"`
+	// Column of Offset in bytes, starting at 1.
+	Column int `json:"column"`
+	// Old holds the original bytes that were replaced.
+	Old string `json:"old"`
+	// New holds the bytes replacing them.
+	New string `json:"new"`
+}
+
+// Edits returns the edits turning old into new. html tokens are
+// compared rather than lines so that minified documents get
+// precise edits as well.
+func Edits(old, new string) []Edit {
+	a, b := splitTokens(old), splitTokens(new)
+
+	var edits []Edit
+	offset, newOffset := 0, 0
+	line, column := 1, 1
+	for _, c := range compare(a, b) {
+		oldText := old[offset : offset+length(a[c.a0:c.a1])]
+		newText := new[newOffset : newOffset+length(b[c.b0:c.b1])]
+
+		if c.changed {
+			// the tokens usually only differ partly
+			prefix := commonPrefix(oldText, newText)
+			suffix := commonSuffix(oldText[prefix:], newText[prefix:])
+			editLine, editColumn := advance(line, column, oldText[:prefix])
+			edits = append(edits, Edit{
+				Offset: offset + prefix,
+				Line:   editLine,
+				Column: editColumn,
+				Old:    oldText[prefix : len(oldText)-suffix],
+				New:    newText[prefix : len(newText)-suffix],
+			})
+		}
+
+		line, column = advance(line, column, oldText)
+		offset += len(oldText)
+		newOffset += len(newText)
+	}
+
+	return edits
+}
+
+// Unified returns the unified diff turning old into
+// new, it is empty when both are the same.
+func Unified(oldName, newName, old, new string) string {
+	a, b := splitLines(old), splitLines(new)
+	chunks := compare(a, b)
+
+	sb := &strings.Builder{}
+	for i := 0; i < len(chunks); i++ {
+		if !chunks[i].changed {
+			continue
+		}
+
+		// changes separated by less than twice
+		// the context share a single hunk
+		last := i
+		for j := i + 1; j < len(chunks); j++ {
+			if chunks[j].changed {
+				last = j
+				continue
+			}
+			if chunks[j].a1-chunks[j].a0 > 2*contextLines {
+				break
+			}
+		}
+
+		a0, b0 := chunks[i].a0, chunks[i].b0
+		if i > 0 {
+			a0 = max(chunks[i-1].a0, a0-contextLines)
+			b0 = max(chunks[i-1].b0, b0-contextLines)
+		}
+		a1, b1 := chunks[last].a1, chunks[last].b1
+		if last+1 < len(chunks) {
+			a1 = min(chunks[last+1].a1, a1+contextLines)
+			b1 = min(chunks[last+1].b1, b1+contextLines)
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(a0, a1), hunkRange(b0, b1))
+
+		for j := max(i-1, 0); j <= last+1 && j < len(chunks); j++ {
+			c := chunks[j]
+			if !c.changed {
+				writeLines(sb, ' ', a[max(c.a0, a0):min(c.a1, a1)])
+				continue
+			}
+			writeLines(sb, '-', a[c.a0:c.a1])
+			writeLines(sb, '+', b[c.b0:c.b1])
+		}
+		i = last
+	}
+
+	return sb.String()
+}
+
+// hunkRange formats the lines from start to end
+// the way the hunk headers of unified diffs do.
+func hunkRange(start, end int) string {
+	if end-start == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	if end == start {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func writeLines(sb *strings.Builder, prefix byte, lines []string) {
+	for _, line := range lines {
+		sb.WriteByte(prefix)
+		sb.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits s after each new line.
+func splitLines(s string) []string {
+	var lines []string
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		lines = append(lines, s[:i])
+		s = s[i:]
+	}
+	return lines
+}
+
+// splitTokens splits s before each tag opener and
+// after each tag closer or new line.
+func splitTokens(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		i := strings.IndexAny(s[1:], "<>\n") + 1
+		switch {
+		case i == 0:
+			i = len(s)
+		case s[i] != '<':
+			i++
+		}
+		tokens = append(tokens, s[:i])
+		s = s[i:]
+	}
+	return tokens
+}
+
+func length(units []string) (n int) {
+	for _, unit := range units {
+		n += len(unit)
+	}
+	return
+}
+
+// advance returns the position following s when
+// it starts at the given line and column.
+func advance(line, column int, s string) (int, int) {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return line + strings.Count(s, "\n"), len(s) - i
+	}
+	return line, column + len(s)
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
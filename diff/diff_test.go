@@ -0,0 +1,162 @@
+package diff
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// apply applies the edits to old the way a consumer would.
+func apply(old string, edits []Edit) string {
+	sb := &strings.Builder{}
+	offset := 0
+	for _, edit := range edits {
+		sb.WriteString(old[offset:edit.Offset])
+		sb.WriteString(edit.New)
+		offset = edit.Offset + len(edit.Old)
+	}
+	sb.WriteString(old[offset:])
+	return sb.String()
+}
+
+func TestEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		expected []Edit
+	}{
+		{
+			name: "same",
+			old:  `<html><body></body></html>`,
+			new:  `<html><body></body></html>`,
+		},
+		{
+			name: "minified",
+			old:  `<html><head></head><body><div id="a">old</div><img src="a.png"></body></html>`,
+			new:  `<html><head><script></script></head><body><div id="a">new</div><img src="b.png"></body></html>`,
+			expected: []Edit{
+				{Offset: 12, Line: 1, Column: 13, Old: "", New: "<script></script>"},
+				{Offset: 37, Line: 1, Column: 38, Old: "old", New: "new"},
+				{Offset: 56, Line: 1, Column: 57, Old: "a", New: "b"},
+			},
+		},
+		{
+			name: "lines",
+			old:  "<html>\n  <body>\n    <p>old</p>\n  </body>\n</html>\n",
+			new:  "<html>\n  <body>\n    <p>new</p>\n  </body>\n</html>\n",
+			expected: []Edit{
+				{Offset: 23, Line: 3, Column: 8, Old: "old", New: "new"},
+			},
+		},
+		{
+			name: "removal",
+			old:  "<body>\n<div id=\"banner\">\n</div>\n<p>text</p></body>",
+			new:  "<body>\n\n<p>text</p></body>",
+			expected: []Edit{
+				{Offset: 7, Line: 2, Column: 1, Old: "<div id=\"banner\">\n</div>", New: ""},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edits := Edits(test.old, test.new)
+			assert.Equal(t, test.expected, edits)
+			assert.Equal(t, test.new, apply(test.old, edits))
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	new := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no"
+
+	expected := `--- a.html
++++ b.html
+@@ -1,6 +1,6 @@
+ a
+ b
+-c
++C
+ d
+ e
+ f
+@@ -12,3 +12,4 @@
+ l
+ m
+ n
++o
+\ No newline at end of file
+`
+	assert.Equal(t, expected, Unified("a.html", "b.html", old, new))
+	assert.Empty(t, Unified("a.html", "b.html", old, old))
+
+	t.Run("joined hunks", func(t *testing.T) {
+		expected := `--- a
++++ b
+@@ -1,5 +1,4 @@
+-a
+ b
+ c
+ d
+-e
++E
+`
+		assert.Equal(t, expected, Unified("a", "b", "a\nb\nc\nd\ne\n", "b\nc\nd\nE\n"))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n", Unified("a", "b", "", "a\n"))
+		assert.Equal(t, "--- a\n+++ b\n@@ -1 +0,0 @@\n-a\n", Unified("a", "b", "a\n", ""))
+	})
+}
+
+func TestCompare(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	letters := func() []string {
+		units := make([]string, random.Intn(30))
+		for i := range units {
+			units[i] = string(rune('a' + random.Intn(4)))
+		}
+		return units
+	}
+
+	for i := 0; i < 1000; i++ {
+		a, b := letters(), letters()
+		var old, new []string
+		common := 0
+		for _, c := range compare(a, b) {
+			if !c.changed {
+				assert.Equal(t, a[c.a0:c.a1], b[c.b0:c.b1])
+				common += c.a1 - c.a0
+			}
+			old = append(old, a[c.a0:c.a1]...)
+			new = append(new, b[c.b0:c.b1]...)
+		}
+		assert.Equal(t, strings.Join(a, ""), strings.Join(old, ""))
+		assert.Equal(t, strings.Join(b, ""), strings.Join(new, ""))
+		assert.Equal(t, lcs(a, b), common)
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
@@ -0,0 +1,112 @@
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/html-overwrite/diff"
+	"github.com/html-overwrite/model"
+	"github.com/html-overwrite/stream"
+	"io"
+	"strings"
+)
+
+// Engine is an implementation html can be rewritten with.
+type Engine uint8
+
+const (
+	// StreamEngine rewrites the html as it is read,
+	// see the stream package.
+	StreamEngine Engine = iota
+	// StdEngine loads the whole document before
+	// rewriting it, see the std package.
+	StdEngine
+	// LosslessEngine loads the whole document before rewriting
+	// it and keeps its bytes outside of the edited regions.
+	LosslessEngine
+)
+
+func (e Engine) String() string {
+	switch e {
+	case StreamEngine:
+		return "stream"
+	case StdEngine:
+		return "std"
+	case LosslessEngine:
+		return "lossless"
+	default:
+		return fmt.Sprintf("Engine(%d)", uint8(e))
+	}
+}
+
+// Rewrite applies the rules to the html read from r and writes
+// the result to w. The document is written even when some of the
+// rules matched nothing, which is reported as ErrNotFound.
+// Invalid rules are reported before anything is read or written.
+func (e Engine) Rewrite(r io.Reader, w io.Writer, rules ...stream.Rule) error {
+	if err := stream.ValidateRules(rules...); err != nil {
+		return err
+	}
+
+	switch e {
+	case StreamEngine:
+		return stream.Rewrite(r, w, rules...)
+	case StdEngine, LosslessEngine:
+		doc, err := e.load(r)
+		if err != nil {
+			return err
+		}
+
+		err = ApplyRules(doc, rules...)
+		if _, werr := io.WriteString(w, doc.String()); werr != nil {
+			return werr
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown engine %v", e)
+	}
+}
+
+// load loads the document for the engines rewriting it whole.
+func (e Engine) load(r io.Reader) (model.Editor, error) {
+	switch e {
+	case StdEngine:
+		return Load(r)
+	case LosslessEngine:
+		return LoadLossless(r)
+	default:
+		return nil, fmt.Errorf("unknown engine %v", e)
+	}
+}
+
+// Changes are the changes a rewrite makes to a document.
+type Changes struct {
+	Original  string
+	Rewritten string
+}
+
+// Edits returns the edits turning the original
+// document into the rewritten one.
+func (c *Changes) Edits() []diff.Edit {
+	return diff.Edits(c.Original, c.Rewritten)
+}
+
+// Unified returns the unified diff of the document with the
+// given name, it is empty when nothing was changed.
+func (c *Changes) Unified(name string) string {
+	return diff.Unified("a/"+name, "b/"+name, c.Original, c.Rewritten)
+}
+
+// DryRun reports the changes applying the rules to the html read
+// from r with the given engine would make. Rules that matched
+// nothing are reported as ErrNotFound along with the changes.
+func DryRun(r io.Reader, engine Engine, rules ...stream.Rule) (*Changes, error) {
+	original := &strings.Builder{}
+	rewritten := &strings.Builder{}
+
+	err := engine.Rewrite(io.TeeReader(r, original), rewritten, rules...)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	return &Changes{Original: original.String(), Rewritten: rewritten.String()}, err
+}
@@ -0,0 +1,73 @@
+package rewrite
+
+import (
+	"bytes"
+	"errors"
+	"github.com/html-overwrite/diff"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestEngineRewrite(t *testing.T) {
+	const page = `<html><head></head><body><div id="content"></div></body></html>`
+
+	for _, engine := range []Engine{StreamEngine, StdEngine} {
+		t.Run(engine.String(), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := engine.Rewrite(strings.NewReader(page), out,
+				stream.SetRule("id=content", "text"),
+				stream.RemoveRule("id=missing"),
+			)
+			assert.True(t, errors.Is(err, ErrNotFound))
+			assert.Equal(t, `<html><head></head><body><div id="content">text</div></body></html>`, out.String())
+		})
+	}
+
+	t.Run("invalid rules", func(t *testing.T) {
+		for _, engine := range []Engine{StreamEngine, StdEngine, LosslessEngine} {
+			for _, path := range []string{"content", "id=content,main"} {
+				out := &bytes.Buffer{}
+				err := engine.Rewrite(strings.NewReader(page), out, stream.SetRule("id=content", "text"), stream.SetRule(path, "text"))
+				assert.EqualError(t, err, `rule 1: invalid path "`+path+`"`, engine.String())
+				assert.Empty(t, out.String())
+			}
+		}
+	})
+
+	assert.NotNil(t, Engine(7).Rewrite(strings.NewReader(page), &bytes.Buffer{}))
+	assert.Equal(t, "Engine(7)", Engine(7).String())
+}
+
+func TestDryRun(t *testing.T) {
+	const page = "<html>\n<head></head>\n<body><div id=\"content\">old</div></body>\n</html>\n"
+
+	for _, engine := range []Engine{StreamEngine, StdEngine} {
+		t.Run(engine.String(), func(t *testing.T) {
+			changes, err := DryRun(strings.NewReader(page), engine, stream.SetRule("id=content", "new"))
+			assert.Nil(t, err)
+			assert.Equal(t, page, changes.Original)
+
+			edits := changes.Edits()
+			if assert.NotEmpty(t, edits) {
+				assert.Contains(t, edits, diff.Edit{Offset: 45, Line: 3, Column: 25, Old: "old", New: "new"})
+			}
+			assert.Contains(t, changes.Unified("index.html"), "--- a/index.html\n+++ b/index.html\n")
+			assert.Contains(t, changes.Unified("index.html"), `+<body><div id="content">new</div>`)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		changes, err := DryRun(strings.NewReader(page), StreamEngine, stream.RemoveRule("id=missing"))
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Empty(t, changes.Edits())
+		assert.Empty(t, changes.Unified("index.html"))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		changes, err := DryRun(strings.NewReader(`<html><body><div id="content">`), StreamEngine, stream.RemoveRule("id=content"))
+		assert.NotNil(t, err)
+		assert.Nil(t, changes)
+	})
+}
@@ -0,0 +1,205 @@
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/html-overwrite/stream"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// FileStats reports the rewrite of a single html file.
+type FileStats struct {
+	// Path is the slash separated path of the file in the source.
+	Path string
+	// Matched is the amount of rules applied to an element of the file.
+	Matched int
+	// Missing holds the paths of the rules that matched nothing.
+	Missing []string
+	// Err is the error the file failed with, if any.
+	Err error
+}
+
+// RewriteFS applies the rules to every html file of src in parallel
+// using the stream engine and writes the results to the dst directory,
+// the rest of the files are copied as is. Rules that matched nothing in
+// a file are reported in its stats rather than failing it. The stats
+// are ordered by path, the first file that failed is returned as error.
+// Invalid rules are reported before anything is written.
+func RewriteFS(src fs.FS, dst string, rules ...stream.Rule) ([]FileStats, error) {
+	if err := stream.ValidateRules(rules...); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, filepath.FromSlash(name)), 0755)
+		}
+		if d.Type().IsRegular() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// html files get stats, indexed by their position in files
+	stats := make([]*FileStats, len(files))
+	errs := make([]error, len(files))
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for n := runtime.GOMAXPROCS(0); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				target := filepath.Join(dst, filepath.FromSlash(files[i]))
+				stats[i], errs[i] = writeFile(src, files[i], target, rules)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := []FileStats{}
+	for i := range files {
+		if stats[i] != nil {
+			result = append(result, *stats[i])
+		}
+		if errs[i] != nil && err == nil {
+			err = fmt.Errorf("%s: %w", files[i], errs[i])
+		}
+	}
+
+	return result, err
+}
+
+// IsHTMLFile reports whether the file with the given
+// name holds html based on its extension.
+func IsHTMLFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+// RewriteFile writes the file of src with the given name to w. html
+// files have the rules applied to them by the engine and their stats
+// returned, rules that matched nothing are reported in the stats rather
+// than failing the file. The rest of the files are copied as is and
+// have no stats.
+func (e Engine) RewriteFile(src fs.FS, name string, w io.Writer, rules ...stream.Rule) (*FileStats, error) {
+	in, err := src.Open(name)
+	if err != nil {
+		return failedStats(name, err)
+	}
+	defer in.Close()
+
+	if !IsHTMLFile(name) {
+		_, err = io.Copy(w, in)
+		return nil, err
+	}
+
+	stats := &FileStats{Path: name}
+	matched, err := e.rewriteMatches(in, w, rules)
+	for i := range matched {
+		if matched[i] {
+			stats.Matched++
+		} else {
+			stats.Missing = append(stats.Missing, rules[i].Path)
+		}
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		stats.Err = err
+	}
+	return stats, stats.Err
+}
+
+// rewriteMatches applies the rules the way Rewrite does,
+// reporting which of them matched an element.
+func (e Engine) rewriteMatches(r io.Reader, w io.Writer, rules []stream.Rule) ([]bool, error) {
+	if e == StreamEngine {
+		matches, err := stream.RewriteMatches(r, w, rules...)
+		matched := make([]bool, len(matches))
+		for i, m := range matches {
+			matched[i] = m.Matched
+		}
+		return matched, err
+	}
+
+	if err := stream.ValidateRules(rules...); err != nil {
+		return nil, err
+	}
+	doc, err := e.load(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rules are applied one at a time to
+	// tell those that matched nothing apart
+	matched := make([]bool, len(rules))
+	var notFound error
+	for i, rule := range rules {
+		required := rule
+		required.Optional = false
+
+		err := ApplyRules(doc, required)
+		if errors.Is(err, ErrNotFound) {
+			if !rule.Optional && notFound == nil {
+				notFound = err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		matched[i] = true
+	}
+
+	if _, err := io.WriteString(w, doc.String()); err != nil {
+		return nil, err
+	}
+	return matched, notFound
+}
+
+// failedStats returns the stats of the file with the given
+// name failing with err, files other than html have none.
+func failedStats(name string, err error) (*FileStats, error) {
+	if !IsHTMLFile(name) {
+		return nil, err
+	}
+	return &FileStats{Path: name, Err: err}, err
+}
+
+// writeFile writes the file of src with the given name to
+// target using the stream engine, as RewriteFile does.
+func writeFile(src fs.FS, name, target string, rules []stream.Rule) (*FileStats, error) {
+	out, err := os.Create(target)
+	if err != nil {
+		return failedStats(name, err)
+	}
+	defer out.Close()
+
+	stats, err := StreamEngine.RewriteFile(src, name, out, rules...)
+	if err != nil {
+		return stats, err
+	}
+
+	if err = out.Close(); err != nil && stats != nil {
+		stats.Err = err
+	}
+	return stats, err
+}
@@ -0,0 +1,123 @@
+package rewrite
+
+import (
+	"bytes"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRewriteFS(t *testing.T) {
+	const page = `<html><head></head><body><div id="banner"></div></body></html>`
+
+	src := fstest.MapFS{
+		"index.html":         {Data: []byte(page)},
+		"about/index.html":   {Data: []byte(`<html><head></head><body></body></html>`)},
+		"blog/post/page.HTM": {Data: []byte(page)},
+		"css/site.css":       {Data: []byte(`#banner { color: red; }`)},
+		"img/logo.svg":       {Data: []byte(`<svg id="banner"></svg>`)},
+		"empty":              {Mode: 0755 | 1<<31},
+	}
+	dst := t.TempDir()
+
+	stats, err := RewriteFS(src, dst,
+		stream.SetRule("id=banner", "staging"),
+		stream.AppendRule("tag=head", "<script></script>"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []FileStats{
+		{Path: "about/index.html", Matched: 1, Missing: []string{"id=banner"}},
+		{Path: "blog/post/page.HTM", Matched: 2},
+		{Path: "index.html", Matched: 2},
+	}, stats)
+
+	rewritten := `<html><head><script></script></head><body><div id="banner">staging</div></body></html>`
+	expected := map[string]string{
+		"index.html":         rewritten,
+		"about/index.html":   `<html><head><script></script></head><body></body></html>`,
+		"blog/post/page.HTM": rewritten,
+		"css/site.css":       `#banner { color: red; }`,
+		"img/logo.svg":       `<svg id="banner"></svg>`,
+	}
+	for name, content := range expected {
+		actual, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(actual), name)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dst, "empty"))
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRewriteFSErrors(t *testing.T) {
+	src := fstest.MapFS{
+		"a.html": {Data: []byte(`<html><body><div id="x">`)},
+		"b.html": {Data: []byte(`<html><body><div id="x"></div></body></html>`)},
+	}
+
+	stats, err := RewriteFS(src, t.TempDir(), stream.SetRule("id=x", "text"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "a.html")
+	}
+	assert.Len(t, stats, 2)
+	assert.NotNil(t, stats[0].Err)
+	assert.Nil(t, stats[1].Err)
+
+	_, err = RewriteFS(src, filepath.Join(t.TempDir(), "missing", "\x00"))
+	assert.NotNil(t, err)
+
+	t.Run("invalid rules", func(t *testing.T) {
+		dst := t.TempDir()
+		stats, err := RewriteFS(src, dst, stream.SetRule("id=x", "text"), stream.SetRule("content", "text"))
+		assert.EqualError(t, err, `rule 1: invalid path "content"`)
+		assert.Nil(t, stats)
+
+		entries, err := os.ReadDir(dst)
+		assert.Nil(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestRewriteFile(t *testing.T) {
+	src := fstest.MapFS{
+		"index.html": {Data: []byte(`<html><head></head><body><div id="x"></div></body></html>`)},
+		"site.css":   {Data: []byte(`#x {}`)},
+	}
+	rules := []stream.Rule{
+		stream.SetRule("id=x", "text"),
+		stream.RemoveRule("id=missing"),
+		{Action: stream.RemoveAction, Path: "id=gone", Optional: true},
+	}
+
+	for _, engine := range []Engine{StreamEngine, StdEngine, LosslessEngine} {
+		t.Run(engine.String(), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			stats, err := engine.RewriteFile(src, "index.html", out, rules...)
+			assert.Nil(t, err)
+			assert.Equal(t, &FileStats{Path: "index.html", Matched: 1, Missing: []string{"id=missing", "id=gone"}}, stats)
+			assert.Equal(t, `<html><head></head><body><div id="x">text</div></body></html>`, out.String())
+
+			out.Reset()
+			stats, err = engine.RewriteFile(src, "site.css", out, rules...)
+			assert.Nil(t, err)
+			assert.Nil(t, stats)
+			assert.Equal(t, `#x {}`, out.String())
+
+			stats, err = engine.RewriteFile(src, "missing.html", out, rules...)
+			assert.NotNil(t, err)
+			assert.Equal(t, err, stats.Err)
+		})
+	}
+}
+
+func TestIsHTMLFile(t *testing.T) {
+	assert.True(t, IsHTMLFile("blog/index.html"))
+	assert.True(t, IsHTMLFile("PAGE.HTM"))
+	assert.False(t, IsHTMLFile("site.css"))
+	assert.False(t, IsHTMLFile("html"))
+}
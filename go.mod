@@ -5,4 +5,6 @@ go 1.16
 require (
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
+	golang.org/x/text v0.3.6
+	gopkg.in/yaml.v3 v3.0.1
 )
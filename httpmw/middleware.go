@@ -0,0 +1,120 @@
+// Package httpmw provides net/http middleware rewriting
+// html responses on their way to the client.
+package httpmw
+
+import (
+	"fmt"
+	"github.com/html-overwrite/stream"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Middleware returns a middleware applying the given rules to
+// every text/html response of the wrapped handler as it is
+// written. Other responses are passed through untouched.
+// Rewritten responses are streamed and their length isn't
+// known until they are written whole, so their Content-Length
+// header is dropped and they are sent chunked, or delimited by
+// closing the connection to HTTP/1.0 clients. Since their headers
+// are already sent by the time a rule fails, rules not being
+// applied don't interrupt the response.
+//
+// The rules are validated up front, Middleware panics
+// if any of them is invalid.
+func Middleware(rules ...stream.Rule) func(http.Handler) http.Handler {
+	if err := stream.ValidateRules(rules...); err != nil {
+		panic(fmt.Errorf("httpmw: %w", err))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w, rules: rules}
+			defer rw.close()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// responseWriter decides whether to rewrite the response
+// once its headers are written.
+type responseWriter struct {
+	http.ResponseWriter
+	rules       []stream.Rule
+	wroteHeader bool
+	rewriter    io.WriteCloser // set when the response is rewritten
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	// informational responses precede the actual one
+	if code >= 100 && code <= 199 {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	rw.wroteHeader = true
+
+	if rw.Header().Get("Content-Encoding") == "" && isHTML(rw.Header()) && bodyAllowed(code) {
+		// the length of the rewritten body isn't known
+		// up front, so the response is sent without it
+		rw.Header().Del("Content-Length")
+		config := stream.Config{ContentType: rw.Header().Get("Content-Type")}
+		rw.rewriter = config.NewWriter(rw.ResponseWriter, rw.rules...)
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		// same as net/http, the content type is
+		// sniffed from the first write when unset
+		if _, ok := rw.Header()["Content-Type"]; !ok && len(p) > 0 {
+			rw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.rewriter != nil {
+		return rw.rewriter.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush sends whatever was rewritten so far to the client,
+// markup that wasn't fully written yet is held back. So is the
+// start of the html when the Content-Type has no charset, until
+// its encoding is known: a meta tag declared it, the head ended
+// or 1024 bytes were written.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) close() {
+	if rw.rewriter != nil {
+		_ = rw.rewriter.Close()
+	}
+}
+
+// isHTML checks whether the headers describe an html body.
+func isHTML(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && mediaType == "text/html"
+}
+
+// bodyAllowed reports whether a response with the
+// given status code may have a body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified
+}
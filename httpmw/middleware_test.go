@@ -0,0 +1,137 @@
+package httpmw
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testHTML = `<html><head></head><body><div id="content"></div></body></html>`
+
+const injectedValue = "<script>alert(1)</script>"
+
+const expectedHTML = `<html><head><script>alert(1)</script></head><body><div id="content"></div></body></html>`
+
+func serve(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	h := Middleware(stream.AppendRule("tag=head", injectedValue))(handler)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("html", func(t *testing.T) {
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", fmt.Sprint(len(testHTML)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(testHTML))
+		})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, expectedHTML, recorder.Body.String())
+	})
+
+	t.Run("sniffed html", func(t *testing.T) {
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(testHTML[:10]))
+			_, _ = w.Write([]byte(testHTML[10:]))
+		})
+
+		assert.Equal(t, expectedHTML, recorder.Body.String())
+	})
+
+	t.Run("charset", func(t *testing.T) {
+		h := Middleware(stream.AppendRule("tag=head", "<title>é</title>"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			_, _ = w.Write([]byte(testHTML))
+		}))
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, strings.Replace(testHTML, "</head>", "<title>\xe9</title></head>", 1), recorder.Body.String())
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		assert.PanicsWithError(t, `httpmw: rule 0: invalid path "content"`, func() {
+			Middleware(stream.SetRule("content", "x"))
+		})
+	})
+
+	t.Run("non html", func(t *testing.T) {
+		body := `{"html":"<html><head></head></html>"}`
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+			_, _ = w.Write([]byte(body))
+		})
+
+		assert.Equal(t, fmt.Sprint(len(body)), recorder.Header().Get("Content-Length"))
+		assert.Equal(t, body, recorder.Body.String())
+	})
+
+	t.Run("encoded html", func(t *testing.T) {
+		compressed := &bytes.Buffer{}
+		gw := gzip.NewWriter(compressed)
+		_, _ = gw.Write([]byte(testHTML))
+		_ = gw.Close()
+
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(compressed.Bytes())
+		})
+
+		assert.Equal(t, compressed.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotModified)
+		})
+
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Equal(t, 0, recorder.Body.Len())
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		recorder := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><head></head><body>"))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte("</body></html>"))
+		})
+
+		assert.True(t, recorder.Flushed)
+		assert.Equal(t, "<html><head><script>alert(1)</script></head><body></body></html>", recorder.Body.String())
+	})
+
+	t.Run("server", func(t *testing.T) {
+		server := httptest.NewServer(Middleware(stream.AppendRule("tag=head", injectedValue))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("Content-Length", fmt.Sprint(len(testHTML)))
+				_, _ = w.Write([]byte(testHTML))
+			}),
+		))
+		defer server.Close()
+
+		res, err := http.Get(server.URL)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedHTML, string(body))
+		assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/html"))
+	})
+}
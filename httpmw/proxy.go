@@ -0,0 +1,192 @@
+package httpmw
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/html-overwrite/stream"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ModifyResponse returns a hook for httputil.ReverseProxy applying
+// the given rules to text/html responses of the upstream as they
+// are streamed to the client. Responses of any other content type
+// are left alone.
+//
+// The rules are validated up front, ModifyResponse panics
+// if any of them is invalid.
+func ModifyResponse(rules ...stream.Rule) func(*http.Response) error {
+	if err := stream.ValidateRules(rules...); err != nil {
+		panic(fmt.Errorf("httpmw: %w", err))
+	}
+
+	return func(res *http.Response) error {
+		if !isHTML(res.Header) {
+			return nil
+		}
+		return rewriteResponse(res, rules)
+	}
+}
+
+// RewriteResponse replaces the body of res with one that has the
+// given rules applied to it as it is read, regardless of its content
+// type. gzip and deflate encoded bodies are decoded, rewritten and
+// encoded back, bodies of any other encoding are left alone. Bodies
+// none of the rules matched are passed on unchanged. Invalid rules
+// are reported before the body is replaced.
+func RewriteResponse(res *http.Response, rules ...stream.Rule) error {
+	if err := stream.ValidateRules(rules...); err != nil {
+		return err
+	}
+	return rewriteResponse(res, rules)
+}
+
+// rewriteResponse rewrites the body of res with rules already validated.
+func rewriteResponse(res *http.Response, rules []stream.Rule) error {
+	if !bodyAllowed(res.StatusCode) || res.Body == nil || res.Body == http.NoBody {
+		return nil
+	}
+
+	config := stream.Config{ContentType: res.Header.Get("Content-Type")}
+	body, err := rewriteBody(res.Body, res.Header.Get("Content-Encoding"), config, rules)
+	if err != nil || body == nil {
+		return err
+	}
+
+	res.Body = body
+	res.ContentLength = -1
+	res.Header.Del("Content-Length")
+	return nil
+}
+
+// rewriteBody wraps body so it is rewritten while keeping its
+// content encoding, nil is returned for unsupported encodings.
+func rewriteBody(body io.ReadCloser, encoding string, config stream.Config, rules []stream.Rule) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		src := &sourceReader{r: body}
+		rewritten := config.NewReader(src, rules...)
+		return &bodyReader{
+			Reader:  passThrough{rewritten, src},
+			closers: []io.Closer{rewritten, body},
+		}, nil
+
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return newEncodingReader(body, gr, func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		}, config, rules), nil
+
+	case "deflate":
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return newEncodingReader(body, zr, func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		}, config, rules), nil
+	}
+
+	return nil, nil
+}
+
+// bodyReader closes the underlying
+// body along with its readers.
+type bodyReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (br *bodyReader) Close() (err error) {
+	for _, c := range br.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// sourceReader remembers the error reading
+// the body failed with.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil && err != io.EOF {
+		sr.err = err
+	}
+	return n, err
+}
+
+// passThrough ends reading normally when none of the rules matched,
+// or when an element they matched wasn't closed by the end of the
+// body, which was passed on whole by then. Errors reading the body
+// still end reading, as the rest of it is missing.
+type passThrough struct {
+	r   io.Reader
+	src *sourceReader
+}
+
+func (pt passThrough) Read(p []byte) (int, error) {
+	n, err := pt.r.Read(p)
+	if errors.Is(err, stream.ErrNotFound) || (errors.Is(err, io.ErrUnexpectedEOF) && pt.src.err == nil) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// encodingReader encodes the rewritten output of a decoded
+// body back as it is being read.
+type encodingReader struct {
+	src   io.Reader
+	enc   io.WriteCloser // encoder writing into out
+	out   bytes.Buffer
+	chunk []byte
+	err   error
+}
+
+func newEncodingReader(body io.ReadCloser, decoder io.ReadCloser, encoder func(io.Writer) io.WriteCloser, config stream.Config, rules []stream.Rule) io.ReadCloser {
+	src := &sourceReader{r: decoder}
+	rewritten := config.NewReader(src, rules...)
+
+	er := &encodingReader{
+		src:   passThrough{rewritten, src},
+		chunk: make([]byte, 32*1024),
+	}
+	er.enc = encoder(&er.out)
+
+	return &bodyReader{Reader: er, closers: []io.Closer{rewritten, decoder, body}}
+}
+
+func (er *encodingReader) Read(p []byte) (int, error) {
+	for er.out.Len() == 0 && er.err == nil {
+		n, err := er.src.Read(er.chunk)
+		if n > 0 {
+			// writing into out can't fail
+			_, _ = er.enc.Write(er.chunk[:n])
+		}
+
+		switch {
+		case err == io.EOF:
+			_ = er.enc.Close()
+			er.err = io.EOF
+		case err != nil:
+			er.err = err
+		}
+	}
+
+	if er.out.Len() > 0 {
+		return er.out.Read(p)
+	}
+	return 0, er.err
+}
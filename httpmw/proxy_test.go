@@ -0,0 +1,204 @@
+package httpmw
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// encode encodes s using the given content encoding.
+func encode(t *testing.T, encoding, s string) []byte {
+	buffer := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(buffer)
+	case "deflate":
+		w = zlib.NewWriter(buffer)
+	default:
+		return []byte(s)
+	}
+	_, err := w.Write([]byte(s))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return buffer.Bytes()
+}
+
+// decode decodes b using the given content encoding.
+func decode(t *testing.T, encoding string, b []byte) string {
+	var r io.Reader = bytes.NewReader(b)
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r, err = zlib.NewReader(r)
+	}
+	assert.Nil(t, err)
+	decoded, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	return string(decoded)
+}
+
+// proxied serves the given upstream response through a reverse
+// proxy and returns the response the client gets.
+func proxied(t *testing.T, contentType, encoding string, body []byte, rules ...stream.Rule) (*http.Response, []byte) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	target, err := url.Parse(upstream.URL)
+	assert.Nil(t, err)
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = ModifyResponse(rules...)
+
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.Nil(t, err)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	res, err := client.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	output, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	return res, output
+}
+
+func TestModifyResponse(t *testing.T) {
+	rule := stream.AppendRule("tag=head", injectedValue)
+
+	for _, encoding := range []string{"", "gzip", "deflate"} {
+		t.Run("html "+encoding, func(t *testing.T) {
+			res, output := proxied(t, "text/html", encoding, encode(t, encoding, testHTML), rule)
+			assert.Equal(t, encoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, expectedHTML, decode(t, encoding, output))
+		})
+	}
+
+	t.Run("charset", func(t *testing.T) {
+		_, output := proxied(t, "text/html; charset=windows-1252", "gzip", encode(t, "gzip", testHTML), stream.AppendRule("tag=head", "<title>é</title>"))
+		assert.Equal(t, strings.Replace(testHTML, "</head>", "<title>\xe9</title></head>", 1), decode(t, "gzip", output))
+	})
+
+	t.Run("non html", func(t *testing.T) {
+		body := encode(t, "gzip", testHTML)
+		_, output := proxied(t, "application/octet-stream", "gzip", body, rule)
+		assert.Equal(t, body, output)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		body := []byte("not really brotli")
+		_, output := proxied(t, "text/html", "br", body, rule)
+		assert.Equal(t, body, output)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		_, output := proxied(t, "text/html", "gzip", encode(t, "gzip", testHTML), stream.AppendRule("id=missing", injectedValue))
+		assert.Equal(t, testHTML, decode(t, "gzip", output))
+	})
+
+	t.Run("unclosed element", func(t *testing.T) {
+		const page = `<html><head></head><body><div id="content">text`
+		for _, encoding := range []string{"", "gzip"} {
+			_, output := proxied(t, "text/html", encoding, encode(t, encoding, page), stream.AppendRule("id=content", injectedValue))
+			assert.Equal(t, page, decode(t, encoding, output))
+		}
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		assert.PanicsWithError(t, `httpmw: rule 1: invalid path "content"`, func() {
+			ModifyResponse(rule, stream.SetRule("content", "x"))
+		})
+	})
+
+	t.Run("invalid gzip", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write([]byte(testHTML))
+		}))
+		defer upstream.Close()
+
+		target, err := url.Parse(upstream.URL)
+		assert.Nil(t, err)
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ModifyResponse = ModifyResponse(rule)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	})
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestRewriteResponse(t *testing.T) {
+	t.Run("read error", func(t *testing.T) {
+		body := io.MultiReader(strings.NewReader(testHTML[:20]), iotest.ErrReader(io.ErrUnexpectedEOF))
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(body)}
+		assert.Nil(t, RewriteResponse(res, stream.AppendRule("tag=head", injectedValue)))
+
+		_, err := ioutil.ReadAll(res.Body)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		body := ioutil.NopCloser(strings.NewReader(testHTML))
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Length": {"64"}}, Body: body}
+		err := RewriteResponse(res, stream.SetRule("id=content,main", "x"))
+		assert.EqualError(t, err, `rule 0: invalid path "id=content,main"`)
+		assert.Equal(t, body, res.Body)
+		assert.Equal(t, "64", res.Header.Get("Content-Length"))
+	})
+
+	t.Run("close", func(t *testing.T) {
+		body := &closeRecorder{Reader: strings.NewReader(testHTML)}
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}
+		assert.Nil(t, RewriteResponse(res, stream.AppendRule("tag=head", injectedValue)))
+
+		assert.Nil(t, res.Body.Close())
+		assert.True(t, body.closed)
+
+		// the parse context went back to the pool
+		_, err := res.Body.Read(make([]byte, 8))
+		assert.EqualError(t, err, "read from a closed reader")
+	})
+}
@@ -0,0 +1,116 @@
+// Package markup holds the parts of the html tokenization rules
+// shared by the engines: the attribute scanner, the detection of
+// the encoding and the tables of elements whose content or end tag
+// is special.
+package markup
+
+import "bytes"
+
+// IsSpace reports whether c is html whitespace.
+func IsSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// Attribute locates an attribute within a tag by offsets,
+// its value excludes the quotes around it.
+type Attribute struct {
+	KeyStart, KeyEnd     int
+	ValueStart, ValueEnd int
+	End                  int // end of the attribute, quotes included
+	HasValue             bool
+}
+
+func (a *Attribute) Key(tag []byte) []byte {
+	return tag[a.KeyStart:a.KeyEnd]
+}
+
+func (a *Attribute) Value(tag []byte) []byte {
+	return tag[a.ValueStart:a.ValueEnd]
+}
+
+// AttributeScanner walks the attributes of tag markup following the
+// html tokenization rules: values may be unquoted or quoted with either
+// quote, quoted ones may hold '>' and whitespace of any kind may
+// surround the '=' and separate the attributes.
+type AttributeScanner struct {
+	Tag         []byte
+	Pos         int       // offset the scanning continues from
+	Attr        Attribute // attribute the scanner is at
+	SelfClosing bool      // the tag ended with "/>"
+}
+
+// ScanAttributes returns a scanner over the attributes
+// of the tag, which start following its name.
+func ScanAttributes(tag []byte) AttributeScanner {
+	return AttributeScanner{Tag: tag, Pos: TagNameEnd(tag)}
+}
+
+// Next moves to the following attribute. false is returned once the
+// tag ended, Pos is then the offset of its closing '>', or the length
+// of the markup in case it ended before the tag did.
+func (s *AttributeScanner) Next() bool {
+	tag := s.Tag
+	i := s.Pos
+
+	slash := false
+	for i < len(tag) && (IsSpace(tag[i]) || tag[i] == '/') {
+		slash = tag[i] == '/'
+		i++
+	}
+	if i >= len(tag) || tag[i] == '>' {
+		s.Pos = i
+		s.SelfClosing = slash && i < len(tag)
+		return false
+	}
+
+	// the first character of a key may be anything but the
+	// ones skipped above, '=' included
+	a := Attribute{KeyStart: i}
+	for i++; i < len(tag) && !IsSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' && tag[i] != '='; i++ {
+	}
+	a.KeyEnd, a.ValueStart, a.ValueEnd, a.End = i, i, i, i
+
+	j := i
+	for j < len(tag) && IsSpace(tag[j]) {
+		j++
+	}
+	if j < len(tag) && tag[j] == '=' {
+		for j++; j < len(tag) && IsSpace(tag[j]); j++ {
+		}
+
+		switch {
+		case j < len(tag) && (tag[j] == '"' || tag[j] == '\''):
+			k := bytes.IndexByte(tag[j+1:], tag[j])
+			if k < 0 {
+				// the quoted value goes on past the markup
+				s.Pos = len(tag)
+				return false
+			}
+			a.ValueStart, a.ValueEnd, a.End = j+1, j+1+k, j+2+k
+		default:
+			a.ValueStart = j
+			for j < len(tag) && !IsSpace(tag[j]) && tag[j] != '>' {
+				j++
+			}
+			a.ValueEnd, a.End = j, j
+		}
+		a.HasValue = true
+	}
+
+	s.Attr = a
+	s.Pos = a.End
+	return true
+}
+
+// TagNameEnd returns the offset following the name of the
+// tag, which starts after its '<' or "</".
+func TagNameEnd(tag []byte) int {
+	i := 1
+	if i < len(tag) && tag[i] == '/' {
+		i++
+	}
+	for i < len(tag) && !IsSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' {
+		i++
+	}
+	return i
+}
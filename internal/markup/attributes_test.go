@@ -0,0 +1,34 @@
+package markup
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScanAttributes(t *testing.T) {
+	t.Run("attributes", func(t *testing.T) {
+		tag := []byte("<input type='text' \n value=\"a > b\" disabled name = q />")
+		var keys, values []string
+		attrs := ScanAttributes(tag)
+		for attrs.Next() {
+			keys = append(keys, string(attrs.Attr.Key(tag)))
+			values = append(values, string(attrs.Attr.Value(tag)))
+		}
+		assert.Equal(t, []string{"type", "value", "disabled", "name"}, keys)
+		assert.Equal(t, []string{"text", "a > b", "", "q"}, values)
+		assert.True(t, attrs.SelfClosing)
+		assert.Equal(t, len(tag)-1, attrs.Pos)
+	})
+
+	t.Run("unterminated quote", func(t *testing.T) {
+		tag := []byte(`<a href="/x title=y>`)
+		attrs := ScanAttributes(tag)
+		assert.False(t, attrs.Next())
+		assert.Equal(t, len(tag), attrs.Pos)
+	})
+
+	t.Run("end tag name", func(t *testing.T) {
+		assert.Equal(t, 4, TagNameEnd([]byte("<div class=a>")))
+		assert.Equal(t, 5, TagNameEnd([]byte("</div>")))
+	})
+}
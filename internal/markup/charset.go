@@ -0,0 +1,252 @@
+package markup
+
+import (
+	"bytes"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"mime"
+)
+
+// prescanLength is the length of the start of documents
+// their encoding is determined from.
+const prescanLength = 1024
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16BEBOM = []byte{0xfe, 0xff}
+	utf16LEBOM = []byte{0xff, 0xfe}
+)
+
+// DetectEncoding determines the encoding of the document starting
+// with b the way browsers do: from its byte order mark, the charset
+// of the Content-Type it was served with or the one its meta tags
+// declare, in this order. The encoding is nil for utf-8 documents,
+// which documents declaring none are taken as.
+func DetectEncoding(b []byte, contentType string) (encoding.Encoding, string) {
+	e, name, _ := EncodingOf(b, contentType, true)
+	return e, name
+}
+
+// EncodingOf is DetectEncoding for documents whose start is still
+// being read, eof telling whether b is all of it. It also reports
+// whether b was enough to tell, which it isn't while b could be the
+// start of a byte order mark or, unless the Content-Type has a known
+// charset, while it is shorter than the prescan and holds neither a
+// declaration nor the end of the head.
+func EncodingOf(b []byte, contentType string, eof bool) (encoding.Encoding, string, bool) {
+	if len(b) >= prescanLength {
+		b, eof = b[:prescanLength], true
+	}
+
+	switch {
+	case bytes.HasPrefix(b, utf8BOM):
+		return nil, "utf-8", true
+	case bytes.HasPrefix(b, utf16BEBOM):
+		e, name := charset.Lookup("utf-16be")
+		return e, name, true
+	case bytes.HasPrefix(b, utf16LEBOM):
+		e, name := charset.Lookup("utf-16le")
+		return e, name, true
+	case !eof && len(b) < len(utf8BOM) &&
+		(bytes.HasPrefix(utf8BOM, b) || bytes.HasPrefix(utf16BEBOM, b) || bytes.HasPrefix(utf16LEBOM, b)):
+		return nil, "", false
+	}
+
+	if contentType != "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+			if e, name := lookupEncoding([]byte(params["charset"])); name != "" {
+				return e, name, true
+			}
+		}
+	}
+
+	label, complete := declaredCharset(b)
+	if !complete && !eof {
+		return nil, "", false
+	}
+
+	if label != nil {
+		e, name := lookupEncoding(label)
+		switch name {
+		case "":
+		case "utf-16be", "utf-16le":
+			// the declaration can't be right
+			// if it was read as ascii
+			return nil, "utf-8", true
+		default:
+			return e, name, true
+		}
+	}
+
+	return nil, "utf-8", true
+}
+
+// lookupEncoding returns the encoding with the given label and
+// its canonical name, which is empty for unknown labels.
+func lookupEncoding(label []byte) (encoding.Encoding, string) {
+	label = bytes.TrimSpace(label)
+	if equalFold(label, "utf-8") || equalFold(label, "utf8") {
+		return nil, "utf-8"
+	}
+
+	e, name := charset.Lookup(string(label))
+	if e == nil || name == "utf-8" {
+		return nil, name
+	}
+	return e, name
+}
+
+// declaredCharset returns the label of the encoding declared by the
+// meta tags of the document starting with b, if any. It also reports
+// whether b was enough to tell, which it is once a declaration or the
+// end of the head is reached.
+func declaredCharset(b []byte) ([]byte, bool) {
+	for i := 0; i < len(b); {
+		j := bytes.IndexByte(b[i:], '<')
+		if j < 0 {
+			return nil, false
+		}
+		i += j
+
+		rest := b[i:]
+		if len(rest) < 2 || (len(rest) < len("<!--") && bytes.HasPrefix([]byte("<!--"), rest)) {
+			return nil, false
+		}
+
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			// the dashes ending the comment may be the ones opening it
+			end := bytes.Index(rest[2:], []byte("-->"))
+			if end < 0 {
+				return nil, false
+			}
+			i += 2 + end + len("-->")
+		case isLetter(rest[1]) || (rest[1] == '/' && len(rest) > 2 && isLetter(rest[2])):
+			// quoted attribute values may hold '>'
+			attrs := ScanAttributes(rest)
+			for attrs.Next() {
+			}
+			if attrs.Pos >= len(rest) {
+				return nil, false
+			}
+
+			tag := rest[:attrs.Pos+1]
+			name := tag[1:TagNameEnd(tag)]
+			switch {
+			case name[0] == '/':
+				if equalFold(name[1:], "head") {
+					return nil, true
+				}
+			case equalFold(name, "body"):
+				return nil, true
+			case equalFold(name, "meta"):
+				if label := metaCharset(tag); label != nil {
+					return label, true
+				}
+			}
+			i += len(tag)
+		case rest[1] == '!' || rest[1] == '/' || rest[1] == '?':
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 1
+		default:
+			i++
+		}
+	}
+	return nil, false
+}
+
+// metaCharset returns the label of the encoding
+// declared by the given meta tag, if any.
+func metaCharset(tag []byte) []byte {
+	var content []byte
+	httpEquiv := false
+
+	attrs := ScanAttributes(tag)
+	for attrs.Next() {
+		key, value := attrs.Attr.Key(tag), attrs.Attr.Value(tag)
+		switch {
+		case equalFold(key, "charset"):
+			return value
+		case equalFold(key, "http-equiv"):
+			httpEquiv = equalFold(bytes.TrimSpace(value), "content-type")
+		case equalFold(key, "content"):
+			content = value
+		}
+	}
+
+	if httpEquiv {
+		return contentCharset(content)
+	}
+	return nil
+}
+
+// contentCharset extracts the charset of the
+// content attribute of a http-equiv meta tag.
+func contentCharset(content []byte) []byte {
+	for {
+		i := indexFold(content, "charset")
+		if i < 0 {
+			return nil
+		}
+		content = bytes.TrimLeft(content[i+len("charset"):], " \t\n\f\r")
+		if len(content) > 0 && content[0] == '=' {
+			break
+		}
+	}
+
+	content = bytes.TrimLeft(content[1:], " \t\n\f\r")
+	if len(content) == 0 {
+		return nil
+	}
+
+	if q := content[0]; q == '"' || q == '\'' {
+		if end := bytes.IndexByte(content[1:], q); end >= 0 {
+			return content[1 : end+1]
+		}
+		return nil
+	}
+
+	end := 0
+	for end < len(content) && !IsSpace(content[end]) && content[end] != ';' {
+		end++
+	}
+	return content[:end]
+}
+
+// indexFold returns the index of the first occurrence of s in b
+// under ASCII case folding, or -1 if there is none.
+func indexFold(b []byte, s string) int {
+	for i := 0; i+len(s) <= len(b); i++ {
+		if equalFold(b[i:i+len(s)], s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalFold reports whether b and s are equal under ASCII case folding.
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range b {
+		if lowerASCII(b[i]) != lowerASCII(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
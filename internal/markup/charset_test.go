@@ -0,0 +1,122 @@
+package markup
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		document    string
+		contentType string
+		expected    string
+	}{
+		{
+			name:     "undeclared",
+			document: `<html><head></head><body>café</body></html>`,
+			expected: "utf-8",
+		},
+		{
+			name:     "utf-8 byte order mark",
+			document: "\xef\xbb\xbf<html><head><meta charset=\"shift_jis\"></head></html>",
+			expected: "utf-8",
+		},
+		{
+			name:     "utf-16 byte order mark",
+			document: "\xff\xfe<\x00h\x00",
+			expected: "utf-16le",
+		},
+		{
+			name:     "meta charset",
+			document: `<html><head><meta charset="Shift_JIS"></head></html>`,
+			expected: "shift_jis",
+		},
+		{
+			name:     "unquoted meta charset",
+			document: `<html><head><META CharSet=windows-1252></head></html>`,
+			expected: "windows-1252",
+		},
+		{
+			name:     "http-equiv",
+			document: `<html><head><meta http-equiv="Content-Type" content="text/html; charset='euc-jp'"></head></html>`,
+			expected: "euc-jp",
+		},
+		{
+			name:     "content without http-equiv",
+			document: `<html><head><meta content="text/html; charset=euc-jp"></head></html>`,
+			expected: "utf-8",
+		},
+		{
+			name:        "content type",
+			document:    `<html><head><meta charset="shift_jis"></head></html>`,
+			contentType: "text/html; charset=ISO-8859-1",
+			expected:    "windows-1252",
+		},
+		{
+			name:        "content type without charset",
+			document:    `<html><head><meta charset="shift_jis"></head></html>`,
+			contentType: "text/html",
+			expected:    "shift_jis",
+		},
+		{
+			name:     "unknown label",
+			document: `<html><head><meta charset="klingon"><meta charset="shift_jis"></head></html>`,
+			expected: "utf-8",
+		},
+		{
+			name:     "declared utf-16",
+			document: `<html><head><meta charset="utf-16"></head></html>`,
+			expected: "utf-8",
+		},
+		{
+			name:     "commented out",
+			document: `<html><head><!-- <meta charset="shift_jis"> --></head></html>`,
+			expected: "utf-8",
+		},
+		{
+			name:     "after the prescan",
+			document: `<html><head><!--` + strings.Repeat(" ", prescanLength) + `--><meta charset="shift_jis"></head></html>`,
+			expected: "utf-8",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, name := DetectEncoding([]byte(test.document), test.contentType)
+			assert.Equal(t, test.expected, name)
+		})
+	}
+}
+
+func TestEncodingOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		start       string
+		contentType string
+		eof         bool
+		expected    string
+		complete    bool
+	}{
+		{name: "head", start: `<html><head><title>a</title>`},
+		{name: "end of the head", start: `<html><head></head>`, expected: "utf-8", complete: true},
+		{name: "body", start: `<html><body>`, expected: "utf-8", complete: true},
+		{name: "meta charset", start: `<html><head><meta charset=latin1>`, expected: "windows-1252", complete: true},
+		{name: "partial tag", start: `<html><head><meta charset="shift`},
+		{name: "partial comment", start: `<html><!-- </head> -`},
+		{name: "content type", start: `<html><head>`, contentType: "text/html; charset=shift_jis", expected: "shift_jis", complete: true},
+		{name: "content type without charset", start: `<html><head>`, contentType: "text/html"},
+		{name: "partial byte order mark", start: "\xef\xbb", contentType: "text/html; charset=shift_jis"},
+		{name: "end of the input", start: `<html><head>`, eof: true, expected: "utf-8", complete: true},
+		{name: "prescan length", start: `<html><head><!--` + strings.Repeat(" ", prescanLength), expected: "utf-8", complete: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, name, complete := EncodingOf([]byte(test.start), test.contentType, test.eof)
+			assert.Equal(t, test.expected, name)
+			assert.Equal(t, test.complete, complete)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package markup
+
+// VoidElements have no content and no end tag.
+var VoidElements = []string{
+	"area", "base", "br", "col", "embed", "hr", "img", "input",
+	"keygen", "link", "meta", "param", "source", "track", "wbr",
+}
+
+// TextElements hold text only, which lasts until their end tag:
+// the raw text of script, style and the like and the escapable
+// raw text of textarea and title. noscript is one of them as
+// scripting is assumed to be enabled.
+var TextElements = []string{
+	"script", "style", "noscript", "xmp", "iframe", "noembed",
+	"noframes", "textarea", "title",
+}
+
+// ParagraphClosers are the start tags ending an open p element.
+var ParagraphClosers = []string{
+	"address", "article", "aside", "blockquote", "details", "dialog", "div",
+	"dl", "fieldset", "figcaption", "figure", "footer", "form", "h1", "h2",
+	"h3", "h4", "h5", "h6", "header", "hgroup", "hr", "main", "menu", "nav",
+	"ol", "p", "pre", "section", "table", "ul",
+}
+
+// ImpliedEnds maps the elements whose end tag may be omitted
+// to the start tags that implicitly end them.
+var ImpliedEnds = map[string][]string{
+	"head":     {"body"},
+	"p":        ParagraphClosers,
+	"li":       {"li"},
+	"dt":       {"dt", "dd"},
+	"dd":       {"dt", "dd"},
+	"option":   {"option", "optgroup"},
+	"optgroup": {"optgroup"},
+	"tr":       {"tr", "tbody", "tfoot"},
+	"td":       {"td", "th", "tr", "tbody", "tfoot"},
+	"th":       {"td", "th", "tr", "tbody", "tfoot"},
+	"thead":    {"tbody", "tfoot"},
+	"tbody":    {"tbody", "tfoot"},
+	"rt":       {"rt", "rp"},
+	"rp":       {"rt", "rp"},
+}
+
+// OptionalEnds are the elements whose end tag may be omitted,
+// the end of the input ends them as well.
+var OptionalEnds = []string{
+	"html", "head", "body", "p", "li", "dt", "dd", "option", "optgroup",
+	"rt", "rp", "colgroup", "caption", "thead", "tbody", "tfoot", "tr",
+	"td", "th",
+}
+
+// ForeignElements hold svg or mathml content, whose elements may be
+// self-closing. The "/>" of html elements is ignored otherwise.
+var ForeignElements = []string{"svg", "math"}
+
+var voidElements = setOf(VoidElements)
+
+var foreignElements = setOf(ForeignElements)
+
+var impliedEnds = func() map[string]map[string]bool {
+	m := make(map[string]map[string]bool, len(ImpliedEnds))
+	for open, next := range ImpliedEnds {
+		m[open] = setOf(next)
+	}
+	return m
+}()
+
+func setOf(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// IsVoid reports whether the element with the given
+// lowercased name is void.
+func IsVoid(name string) bool {
+	return voidElements[name]
+}
+
+// IsForeign reports whether the element with the given
+// lowercased name holds foreign content.
+func IsForeign(name string) bool {
+	return foreignElements[name]
+}
+
+// EndsOnStart reports whether the start tag of next implicitly ends
+// the open element, both given by their lowercased names.
+func EndsOnStart(open, next string) bool {
+	return impliedEnds[open][next]
+}
@@ -0,0 +1,16 @@
+package markup
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestElements(t *testing.T) {
+	assert.True(t, IsVoid("br"))
+	assert.False(t, IsVoid("div"))
+
+	assert.True(t, EndsOnStart("p", "div"))
+	assert.True(t, EndsOnStart("td", "tr"))
+	assert.False(t, EndsOnStart("p", "span"))
+	assert.False(t, EndsOnStart("div", "div"))
+}
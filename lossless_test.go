@@ -0,0 +1,173 @@
+package rewrite
+
+import (
+	"errors"
+	"github.com/html-overwrite/model"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const losslessDocument = `<!DOCTYPE html>
+<html lang=en>
+  <head>
+    <meta charset='utf-8'>
+    <title>Page</title>
+  </head>
+  <body>
+    <div  id="content"   class='main wide'>
+      <p>first
+      <p>second
+      <img src=logo.png alt="">
+      <ul><li>a<li id=last>b</ul>
+    </div>
+    <script>document.write("</div>")</script>
+  </body>
+</html>
+`
+
+func TestLossless(t *testing.T) {
+	tests := []struct {
+		name     string
+		apply    func(doc model.Editor) error
+		old, new string
+	}{
+		{
+			name:  "set",
+			apply: func(doc model.Editor) error { return doc.Set("id=content", "\n      text\n    ") },
+			old: `<div  id="content"   class='main wide'>
+      <p>first
+      <p>second
+      <img src=logo.png alt="">
+      <ul><li>a<li id=last>b</ul>
+    </div>`,
+			new: `<div  id="content"   class='main wide'>
+      text
+    </div>`,
+		},
+		{
+			name:  "append to implicitly closed",
+			apply: func(doc model.Editor) error { return doc.Append("id=last", "<b>c</b>") },
+			old:   `<li id=last>b</ul>`,
+			new:   `<li id=last>b<b>c</b></ul>`,
+		},
+		{
+			name:  "prepend",
+			apply: func(doc model.Editor) error { return doc.Prepend("tag=head", "<base href=/>") },
+			old:   "<head>\n",
+			new:   "<head><base href=/>\n",
+		},
+		{
+			name:  "remove paragraphs",
+			apply: func(doc model.Editor) error { return doc.Remove("tag=p") },
+			old:   "<p>first\n      <p>second\n      <img src=logo.png alt=\"\">\n      <ul>",
+			new:   "<ul>",
+		},
+		{
+			name:  "replace attribute",
+			apply: func(doc model.Editor) error { return doc.SetAttr("class=main", "class", `"narrow"`) },
+			old:   `class='main wide'>`,
+			new:   `class="&#34;narrow&#34;">`,
+		},
+		{
+			name:  "replace unquoted attribute",
+			apply: func(doc model.Editor) error { return doc.SetAttr("tag=img", "src", "new.png") },
+			old:   `<img src=logo.png alt="">`,
+			new:   `<img src="new.png" alt="">`,
+		},
+		{
+			name:  "add attribute",
+			apply: func(doc model.Editor) error { return doc.SetAttr("tag=html", "dir", "ltr") },
+			old:   `<html lang=en>`,
+			new:   `<html lang=en dir="ltr">`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := LoadLossless(strings.NewReader(losslessDocument))
+			assert.Nil(t, err)
+			assert.Equal(t, losslessDocument, doc.String())
+
+			assert.Nil(t, test.apply(doc))
+			assert.Equal(t, strings.Replace(losslessDocument, test.old, test.new, 1), doc.String())
+		})
+	}
+}
+
+func TestLosslessEdits(t *testing.T) {
+	doc, err := LoadLossless(strings.NewReader(`<div class="a"><div class="a"><span id="x"></span></div></div><br class="a">`))
+	assert.Nil(t, err)
+
+	t.Run("nested matches", func(t *testing.T) {
+		assert.Nil(t, doc.Set("class=a", "<i id=\"y\">text</i>"))
+		assert.Equal(t, `<div class="a"><i id="y">text</i></div><br class="a">`, doc.String())
+	})
+
+	t.Run("inserted content", func(t *testing.T) {
+		assert.Nil(t, doc.SetAttr("id=y", "title", "new"))
+		assert.Equal(t, `<div class="a"><i id="y" title="new">text</i></div><br class="a">`, doc.String())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		assert.True(t, errors.Is(doc.Remove("id=x"), ErrNotFound))
+		assert.True(t, errors.Is(doc.Set("tag=br", "text"), ErrNotFound))
+
+		doc, err := LoadLossless(strings.NewReader("<div>\n  <br class=\"a\">\n</div>"))
+		assert.Nil(t, err)
+		assert.EqualError(t, doc.Append("class=a", "text"), `element br matching "class=a" at 2:3 can't have content: no matching element`)
+	})
+
+	t.Run("implied ends", func(t *testing.T) {
+		doc, err := LoadLossless(strings.NewReader(`<table><tr id=a><td>a<tr id=b><td>b</table>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("id=a", "<td>x</td>"))
+		assert.Equal(t, `<table><tr id=a><td>a<td>x</td><tr id=b><td>b</table>`, doc.String())
+	})
+
+	t.Run("self-closing elements", func(t *testing.T) {
+		doc, err := LoadLossless(strings.NewReader(`<div id="a"/><span>x</span></div><svg><path d="1"/><path d="2"/></svg>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Set("id=a", "new"))
+		assert.Nil(t, doc.Remove("tag=path"))
+		assert.Equal(t, `<div id="a"/>new</div><svg></svg>`, doc.String())
+	})
+
+	t.Run("optional ends", func(t *testing.T) {
+		doc, err := LoadLossless(strings.NewReader(`<html><head><title>t</title><body><p>x`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("tag=head", "<base href=/>"))
+		assert.Nil(t, doc.Append("tag=body", "!"))
+		assert.Equal(t, `<html><head><title>t</title><base href=/><body><p>x!`, doc.String())
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		doc, err := LoadLossless(strings.NewReader(`<p>text</p>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("tag=p", "!"))
+		assert.Equal(t, `<p>text!</p>`, doc.String())
+	})
+}
+
+func TestLosslessLocate(t *testing.T) {
+	doc, err := LoadLossless(strings.NewReader(losslessDocument))
+	assert.Nil(t, err)
+	locator := doc.(model.Locator)
+
+	positions, err := locator.Locate("tag=p,class=main")
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Position{
+		{Offset: 114, Line: 8, Column: 5},
+		{Offset: 160, Line: 9, Column: 7},
+		{Offset: 175, Line: 10, Column: 7},
+	}, positions)
+
+	// positions are those of the edited document
+	assert.Nil(t, doc.Prepend("tag=body", "\n    <p>banner</p>"))
+	positions, err = locator.Locate("id=last")
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Position{{Offset: 250, Line: 13, Column: 16}}, positions)
+
+	_, err = locator.Locate("id=missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Position locates a byte of a document. Lines and columns
+// start at 1, columns count bytes rather than characters.
+type Position struct {
+	Offset int // offset from the start of the document
+	Line   int
+	Column int
+}
+
+// StartPosition is the position of the first byte of a document.
+var StartPosition = Position{Line: 1, Column: 1}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Advance returns the position following b,
+// which is expected to start at p.
+func (p Position) Advance(b []byte) Position {
+	p.Offset += len(b)
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		p.Line += bytes.Count(b, newline)
+		p.Column = len(b) - i
+	} else {
+		p.Column += len(b)
+	}
+	return p
+}
+
+var newline = []byte{'\n'}
+
+// PositionOf returns the position of the
+// byte at the given offset of source.
+func PositionOf(source string, offset int) Position {
+	before := source[:offset]
+	p := Position{Offset: offset, Line: 1 + strings.Count(before, "\n")}
+	p.Column = offset - strings.LastIndexByte(before, '\n')
+	return p
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPosition(t *testing.T) {
+	const source = "<p>\n  a\r\n\n<b>"
+
+	p := StartPosition
+	for offset := 0; offset < len(source); offset++ {
+		assert.Equal(t, PositionOf(source, offset), p)
+		p = p.Advance([]byte(source[offset : offset+1]))
+	}
+
+	assert.Equal(t, Position{Offset: 9, Line: 3, Column: 1}, StartPosition.Advance([]byte(source[:9])))
+	assert.Equal(t, "4:2", PositionOf(source, 11).String())
+}
@@ -1,8 +1,16 @@
 package model
 
+import "errors"
+
+// ErrNotFound is returned when no element
+// matched the path of a modification.
+var ErrNotFound = errors.New("no matching element")
+
 // Writer allows mutating HTML nodes
 // at ease using a simple query language
-// and raw html string values.
+// and raw html string values. Modifications
+// fail with ErrNotFound when no node
+// matched their path.
 type Writer interface {
 	// Set will query for nodes matching the
 	// given path and set their content to be the
@@ -16,3 +24,32 @@ type Writer interface {
 	// loaded into the stdLibWriter in a string format.
 	String() string
 }
+
+// Editor is a Writer which can also prepend to, remove and set
+// the attributes of the matched nodes. It is a separate interface
+// so implementations of Writer don't need to support all of them.
+type Editor interface {
+	Writer
+	// Prepend will query for nodes matching the
+	// given path and prepend a new child node
+	// as the given value.
+	Prepend(path string, value string) error
+	// Remove will query for nodes matching the
+	// given path and remove them from the document.
+	Remove(path string) error
+	// SetAttr will query for nodes matching the
+	// given path and set their attribute with the
+	// given name to be the given value.
+	SetAttr(path string, name string, value string) error
+}
+
+// Locator is an Editor which can also tell where the nodes
+// a path matches are in the document it holds.
+type Locator interface {
+	Editor
+	// Locate returns the positions of the start tags of the nodes
+	// matching the given path in the document as it currently is,
+	// in the order they appear in. It fails with ErrNotFound when
+	// no node matched the path.
+	Locate(path string) ([]Position, error)
+}
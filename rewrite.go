@@ -1,15 +1,21 @@
 package rewrite
 
 import (
+	"errors"
+	"fmt"
 	"github.com/html-overwrite/model"
 	"github.com/html-overwrite/std"
 	"github.com/html-overwrite/stream"
 	"io"
 )
 
+// ErrNotFound is returned when no element matched
+// the path of a rule.
+var ErrNotFound = model.ErrNotFound
+
 // Load allows inputting html docs in string format
 // into the stdLibWriter so they could be modified.
-func Load(r io.Reader) (w model.Writer, err error) {
+func Load(r io.Reader) (w model.Editor, err error) {
 	if w, err = std.NewWriter(r); err != nil {
 		return
 	}
@@ -17,6 +23,73 @@ func Load(r io.Reader) (w model.Writer, err error) {
 	return
 }
 
+// LoadFragment loads partial html such as `<div>...</div>`
+// the same way Load does, without adding html, head or
+// body elements around it on output. Partial html holding
+// html, head or body tags is rejected.
+func LoadFragment(r io.Reader) (model.Editor, error) {
+	return std.NewFragmentWriter(r)
+}
+
+// LoadLossless loads html docs the same way Load does, except
+// the document keeps its original bytes outside of the edited
+// regions rather than being rendered again, which keeps diffs
+// of version controlled html small. The document is a
+// model.Locator, telling where the elements a path matches are.
+func LoadLossless(r io.Reader) (model.Editor, error) {
+	return std.NewLosslessWriter(r)
+}
+
+// ApplyRules applies stream rules to a loaded document one after
+// the other, making rule sets usable with the std writer as well.
+// Same as with the stream engine, rules that matched nothing don't
+// stop the rest from being applied and the first of them is reported.
+func ApplyRules(w model.Writer, rules ...stream.Rule) (notFound error) {
+	for _, rule := range rules {
+		var err error
+		switch rule.Action {
+		case stream.SetAction:
+			err = w.Set(rule.Path, rule.Value)
+		case stream.AppendAction:
+			err = w.Append(rule.Path, rule.Value)
+		case stream.PrependAction, stream.RemoveAction, stream.AttrAction:
+			err = applyEdit(w, rule)
+		default:
+			err = fmt.Errorf("unknown action %d", rule.Action)
+		}
+
+		if errors.Is(err, model.ErrNotFound) {
+			if !rule.Optional && notFound == nil {
+				notFound = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+// applyEdit applies the rules whose actions
+// only writers implementing model.Editor support.
+func applyEdit(w model.Writer, rule stream.Rule) error {
+	e, ok := w.(model.Editor)
+	if !ok {
+		return fmt.Errorf("%T can't apply the action of rule %q, it is not a model.Editor", w, rule.Path)
+	}
+
+	switch rule.Action {
+	case stream.PrependAction:
+		return e.Prepend(rule.Path, rule.Value)
+	case stream.RemoveAction:
+		return e.Remove(rule.Path)
+	default:
+		return e.SetAttr(rule.Path, rule.Attribute, rule.Value)
+	}
+}
+
 func Append(r io.Reader, w io.Writer, path, value string) error {
 	return stream.Append(r, w, path, value)
 }
@@ -24,3 +97,15 @@ func Append(r io.Reader, w io.Writer, path, value string) error {
 func Set(r io.Reader, w io.Writer, path, value string) error {
 	return stream.Set(r, w, path, value)
 }
+
+func Prepend(r io.Reader, w io.Writer, path, value string) error {
+	return stream.Prepend(r, w, path, value)
+}
+
+func Remove(r io.Reader, w io.Writer, path string) error {
+	return stream.Remove(r, w, path)
+}
+
+func SetAttr(r io.Reader, w io.Writer, path, name, value string) error {
+	return stream.SetAttr(r, w, path, name, value)
+}
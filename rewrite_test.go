@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"github.com/html-overwrite/model"
+	"github.com/html-overwrite/std"
+	"github.com/html-overwrite/stream"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/html"
+	"io"
 	"io/ioutil"
 	"regexp"
 	"strings"
@@ -131,6 +135,44 @@ func stdLibClassBasedTests(t *testing.T) {
 	})
 }
 
+func stdLibActionTests(t *testing.T) {
+	t.Run("Prepend", func(t *testing.T) {
+		w, err := Load(strings.NewReader(fmt.Sprintf(BaseHTMLTemplate, TestNode)))
+		assert.Nil(t, err)
+
+		err = w.Prepend("id=content", `<h1>Title</h1>`)
+		assert.Nil(t, err)
+		assert.Regexp(t, `<div id="content"><h1>Title</h1>\s*<p>Very Cool</p>`, w.String())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		w, err := Load(strings.NewReader(fmt.Sprintf(BaseHTMLTemplate, DivsWithClassesAndContent)))
+		assert.Nil(t, err)
+
+		err = w.Remove("class=great-name")
+		assert.Nil(t, err)
+		newHTML := w.String()
+		assert.NotContains(t, newHTML, "great-name")
+		assert.NotContains(t, newHTML, "shopping")
+		assert.Contains(t, newHTML, `<div id="content">`)
+	})
+
+	t.Run("SetAttr", func(t *testing.T) {
+		w, err := Load(strings.NewReader(fmt.Sprintf(BaseHTMLTemplate, DivsWithClasses)))
+		assert.Nil(t, err)
+
+		err = w.SetAttr("tag=div", "data-seen", "yes")
+		assert.Nil(t, err)
+		err = w.SetAttr("id=content", "id", "main")
+		assert.Nil(t, err)
+
+		newHTML := w.String()
+		re := regexp.MustCompile(`data-seen="yes"`)
+		assert.Len(t, re.FindAllString(newHTML, -1), 4)
+		assert.Contains(t, newHTML, `<div id="main" data-seen="yes">`)
+	})
+}
+
 func streamIdBasedTests(t *testing.T) {
 
 	t.Run("Set", func(t *testing.T) {
@@ -188,9 +230,159 @@ func TestHtmlMutations(t *testing.T) {
 		})
 		t.Run("id based tests", stdLibIdBasedTests)
 		t.Run("class based tests", stdLibClassBasedTests)
+		t.Run("action tests", stdLibActionTests)
 	})
 	t.Run("stream based", func(t *testing.T) {
 		t.Run("id based tests", streamIdBasedTests)
 		t.Run("tag based tests", streamTagBasedTests)
 	})
 }
+
+func TestLoadFragment(t *testing.T) {
+	doc, err := LoadFragment(strings.NewReader(`<div id="card"><p>old</p></div><p>rest</p>`))
+	assert.Nil(t, err)
+	assert.Nil(t, doc.Set("id=card", "<p>new</p>"))
+	assert.Equal(t, `<div id="card"><p>new</p></div><p>rest</p>`, doc.String())
+
+	t.Run("table rows", func(t *testing.T) {
+		doc, err := LoadFragment(strings.NewReader(`<tr><td>cell</td></tr>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("tag=td", "<b>!</b>"))
+		assert.Equal(t, `<tr><td>cell<b>!</b></td></tr>`, doc.String())
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, fragment := range []string{
+			`<!DOCTYPE html><title>T</title><div id="a"></div>`,
+			"<!DOCTYPE html>\n<!-- card -->\n<div id=\"a\"><p>text</p></div>\n",
+			`<li class="k">a</li><li>b</li>`,
+			`<td>cell</td>`,
+		} {
+			doc, err := LoadFragment(strings.NewReader(fragment))
+			assert.Nil(t, err, fragment)
+			assert.Equal(t, fragment, doc.String())
+		}
+
+		doc, err := LoadFragment(strings.NewReader(`<!DOCTYPE html><title>T</title><div id="a"></div>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Set("id=a", "new"))
+		assert.Equal(t, `<!DOCTYPE html><title>T</title><div id="a">new</div>`, doc.String())
+	})
+
+	t.Run("document tags", func(t *testing.T) {
+		_, err := LoadFragment(strings.NewReader(`<body class="k"><div id="a"></div></body>`))
+		assert.EqualError(t, err, `body tag at 1:1 can't be kept in partial html, load it as a document instead`)
+
+		_, err = LoadFragment(strings.NewReader("<div></div>\n</body></html>"))
+		assert.EqualError(t, err, `body tag at 2:1 can't be kept in partial html, load it as a document instead`)
+
+		_, err = LoadFragment(strings.NewReader(`<div></div><!DOCTYPE html>`))
+		assert.EqualError(t, err, `doctype at 1:12 doesn't start the html`)
+	})
+
+	t.Run("head content", func(t *testing.T) {
+		doc, err := Load(strings.NewReader(`<html><head></head><body></body></html>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("tag=head", `<script src="/a.js"></script>`))
+		assert.Equal(t, `<html><head><script src="/a.js"></script></head><body></body></html>`, doc.String())
+	})
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	const document = `<HTML><Head></Head><BODY><DIV ID="Card" CLASS="Title">old</DIV></BODY></HTML>`
+
+	t.Run("names", func(t *testing.T) {
+		for _, load := range []func(r io.Reader) (model.Editor, error){Load, LoadLossless} {
+			doc, err := load(strings.NewReader(document))
+			assert.Nil(t, err)
+			assert.Nil(t, doc.Set("tag=DIV", "new"))
+			assert.Nil(t, doc.SetAttr("id=Card", "TITLE", "a"))
+			assert.Nil(t, doc.SetAttr("id=Card", "Title", "b"))
+			assert.Contains(t, doc.String(), `="b">new</`)
+			assert.Equal(t, 1, strings.Count(strings.ToLower(doc.String()), `title="`))
+			assert.True(t, errors.Is(doc.Remove("id=card"), ErrNotFound))
+		}
+	})
+
+	t.Run("values", func(t *testing.T) {
+		config := std.Config{FoldValues: true}
+		for _, load := range []func(r io.Reader) (model.Editor, error){config.NewWriter, config.NewLosslessWriter} {
+			doc, err := load(strings.NewReader(document))
+			assert.Nil(t, err)
+			assert.Nil(t, doc.Set("id=card", "new"))
+			assert.Nil(t, doc.Append("class=title", "<b>!</b>"))
+			assert.Contains(t, doc.String(), `>new<b>!</b></`)
+		}
+	})
+}
+
+func TestEncodings(t *testing.T) {
+	const document = `<html><head><meta charset="windows-1252"></head><body><p id="a">caf` + "\xe9" + `</p></body></html>`
+
+	for _, engine := range []Engine{StreamEngine, StdEngine, LosslessEngine} {
+		t.Run(engine.String(), func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			err := engine.Rewrite(strings.NewReader(document), buffer,
+				stream.AppendRule("id=a", "<b>thé ✓</b>"),
+				stream.AttrRule("id=a", "title", "é"),
+			)
+			assert.Nil(t, err)
+			assert.Contains(t, buffer.String(), `<p id="a" title="`+"\xe9"+`">caf`+"\xe9"+`<b>th`+"\xe9"+` &#10003;</b></p>`)
+		})
+	}
+
+	t.Run("content type", func(t *testing.T) {
+		doc, err := std.Config{ContentType: "text/html; charset=iso-8859-15"}.NewWriter(strings.NewReader(`<p id="a"></p>`))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Set("id=a", "€"))
+		assert.Contains(t, doc.String(), `<p id="a">`+"\xa4"+`</p>`)
+	})
+
+	t.Run("utf-16", func(t *testing.T) {
+		const document = "\xff\xfe<\x00p\x00>\x00"
+		doc, err := Load(strings.NewReader(document))
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Append("tag=p", "é"))
+		assert.True(t, strings.HasSuffix(doc.String(), "<\x00p\x00>\x00\xe9\x00<\x00/\x00p\x00>\x00<\x00/\x00b\x00o\x00d\x00y\x00>\x00<\x00/\x00h\x00t\x00m\x00l\x00>\x00"))
+
+		_, err = LoadLossless(strings.NewReader(document))
+		assert.EqualError(t, err, "utf-16le documents are not supported")
+	})
+}
+
+// setWriter is a model.Writer that isn't a model.Editor.
+type setWriter struct {
+	paths []string
+}
+
+func (w *setWriter) Set(path, value string) error {
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func (w *setWriter) Append(path, value string) error {
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func (w *setWriter) String() string {
+	return ""
+}
+
+func TestApplyRules(t *testing.T) {
+	t.Run("editor", func(t *testing.T) {
+		doc, err := Load(strings.NewReader(`<p id="a">x</p><p id="b"></p>`))
+		assert.Nil(t, err)
+		assert.Nil(t, ApplyRules(doc, stream.PrependRule("id=a", "<b>!</b>"), stream.RemoveRule("id=b"), stream.AttrRule("id=a", "title", "t")))
+		assert.Contains(t, doc.String(), `<p id="a" title="t"><b>!</b>x</p></body>`)
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		w := &setWriter{}
+		assert.Nil(t, ApplyRules(w, stream.SetRule("id=a", "x"), stream.AppendRule("id=b", "<b></b>")))
+		assert.Equal(t, []string{"id=a", "id=b"}, w.paths)
+
+		err := ApplyRules(w, stream.RemoveRule("id=a"))
+		assert.EqualError(t, err, `*rewrite.setWriter can't apply the action of rule "id=a", it is not a model.Editor`)
+	})
+}
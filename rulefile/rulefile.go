@@ -0,0 +1,228 @@
+// Package rulefile loads rewrite rules from declarative
+// JSON or YAML files so they can be maintained without
+// recompiling.
+//
+// A rules file lists the rules applied in order:
+//
+//	rules:
+//	  - selector: tag=body
+//	    action: append
+//	    valueFile: snippets/footer.html
+//	  - selector: id=banner
+//	    action: set
+//	    value: <p>Staging</p>
+//	    onMissing: ignore
+//	  - selector: id=logo
+//	    action: attr
+//	    attribute: src
+//	    value: /static/logo.svg
+package rulefile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	rewrite "github.com/html-overwrite"
+	"github.com/html-overwrite/model"
+	"github.com/html-overwrite/stream"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the encoding of a rules file.
+type Format uint8
+
+const (
+	// YAML rules files, the default format.
+	YAML Format = iota
+	// JSON rules files.
+	JSON
+)
+
+// FormatOf returns the format of a rules
+// file based on its extension.
+func FormatOf(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return JSON
+	}
+	return YAML
+}
+
+// Values of the onMissing field.
+const (
+	// OnMissingError fails the rewrite when
+	// no element matched the selector.
+	OnMissingError = "error"
+	// OnMissingIgnore leaves the document as
+	// is when no element matched the selector.
+	OnMissingIgnore = "ignore"
+)
+
+var actions = map[string]stream.Action{
+	"set":     stream.SetAction,
+	"append":  stream.AppendAction,
+	"prepend": stream.PrependAction,
+	"remove":  stream.RemoveAction,
+	"attr":    stream.AttrAction,
+}
+
+// Rule is a single entry of a rules file.
+type Rule struct {
+	// Selector is the path of the element the rule applies to.
+	Selector string `json:"selector" yaml:"selector"`
+	// Action is one of set, append, prepend, remove and attr.
+	Action string `json:"action" yaml:"action"`
+	// Attribute is the name of the attribute set by attr.
+	Attribute string `json:"attribute,omitempty" yaml:"attribute,omitempty"`
+	// Value is the value of the action.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// ValueFile is read as the value of the action, relative
+	// paths are resolved against the rules file directory.
+	ValueFile string `json:"valueFile,omitempty" yaml:"valueFile,omitempty"`
+	// OnMissing is either error, the default, or ignore.
+	OnMissing string `json:"onMissing,omitempty" yaml:"onMissing,omitempty"`
+}
+
+// File is a loaded rules file.
+type File struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+
+	rules      []stream.Rule
+	valueFiles []string
+}
+
+// Load reads the rules file at the given path,
+// its format is picked based on its extension.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := Parse(f, FormatOf(path), filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Parse reads a rules file of the given format from r, value
+// files are resolved against the given directory.
+func Parse(r io.Reader, format Format, dir string) (*File, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	switch format {
+	case JSON:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(file)
+	default:
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(file); err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range file.Rules {
+		rule, err := file.Rules[i].compile(dir)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		file.rules = append(file.rules, rule)
+		if file.Rules[i].ValueFile != "" {
+			file.valueFiles = append(file.valueFiles, file.Rules[i].valueFilePath(dir))
+		}
+	}
+
+	return file, nil
+}
+
+// valueFilePath resolves the value file of
+// the rule against the given directory.
+func (r *Rule) valueFilePath(dir string) string {
+	if filepath.IsAbs(r.ValueFile) {
+		return r.ValueFile
+	}
+	return filepath.Join(dir, r.ValueFile)
+}
+
+// compile turns the rule into a stream rule.
+func (r *Rule) compile(dir string) (stream.Rule, error) {
+	action, ok := actions[r.Action]
+	if !ok {
+		return stream.Rule{}, fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Selector == "" {
+		return stream.Rule{}, errors.New("missing selector")
+	}
+
+	value := r.Value
+	if r.ValueFile != "" {
+		if r.Value != "" {
+			return stream.Rule{}, errors.New("both value and valueFile are set")
+		}
+
+		content, err := ioutil.ReadFile(r.valueFilePath(dir))
+		if err != nil {
+			return stream.Rule{}, err
+		}
+		value = string(content)
+	}
+
+	var optional bool
+	switch r.OnMissing {
+	case "", OnMissingError:
+	case OnMissingIgnore:
+		optional = true
+	default:
+		return stream.Rule{}, fmt.Errorf("unknown onMissing value %q", r.OnMissing)
+	}
+
+	rule := stream.Rule{
+		Action:    action,
+		Path:      r.Selector,
+		Value:     value,
+		Attribute: r.Attribute,
+		Optional:  optional,
+	}
+
+	// the checks the engines make would otherwise only
+	// fail the rules file on the first document
+	if err := rule.Validate(); err != nil {
+		return stream.Rule{}, err
+	}
+	return rule, nil
+}
+
+// StreamRules returns the rules of the file
+// for use with the stream engine.
+func (f *File) StreamRules() []stream.Rule {
+	return f.rules
+}
+
+// ValueFiles returns the paths of the files the values
+// of the rules were read from, in the order of the rules.
+func (f *File) ValueFiles() []string {
+	return f.valueFiles
+}
+
+// Apply applies the rules of the file
+// to a document loaded by the std writer.
+func (f *File) Apply(w model.Writer) error {
+	return rewrite.ApplyRules(w, f.rules...)
+}
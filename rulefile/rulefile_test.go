@@ -0,0 +1,102 @@
+package rulefile
+
+import (
+	"bytes"
+	"errors"
+	rewrite "github.com/html-overwrite"
+	"github.com/html-overwrite/model"
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testHTML     = `<html><head></head><body><img id="logo" src="/logo.png"/></body></html>`
+	expectedHTML = `<html><head></head><body><img id="logo" src="/static/logo.svg"/><footer>Staging</footer></body></html>`
+)
+
+func TestLoad(t *testing.T) {
+	expected := []stream.Rule{
+		stream.AppendRule("tag=body", "<footer>Staging</footer>"),
+		{Action: stream.SetAction, Path: "id=banner", Value: "<p>Staging</p>", Optional: true},
+		stream.AttrRule("id=logo", "src", "/static/logo.svg"),
+	}
+
+	for _, path := range []string{"testdata/rules.yaml", "testdata/rules.json"} {
+		t.Run(path, func(t *testing.T) {
+			file, err := Load(path)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, file.StreamRules())
+			assert.Equal(t, []string{filepath.Join("testdata", "footer.html")}, file.ValueFiles())
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	file, err := Load("testdata/rules.yaml")
+	assert.Nil(t, err)
+
+	t.Run("stream", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.Nil(t, stream.Rewrite(strings.NewReader(testHTML), out, file.StreamRules()...))
+		assert.Equal(t, expectedHTML, out.String())
+	})
+
+	t.Run("std", func(t *testing.T) {
+		doc, err := rewrite.Load(strings.NewReader(testHTML))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Apply(doc))
+		assert.Equal(t, expectedHTML, doc.String())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		file, err := Parse(strings.NewReader("rules:\n  - {selector: id=banner, action: remove}"), YAML, "")
+		assert.Nil(t, err)
+
+		doc, err := rewrite.Load(strings.NewReader(testHTML))
+		assert.Nil(t, err)
+		assert.True(t, errors.Is(file.Apply(doc), model.ErrNotFound))
+
+		err = stream.Rewrite(strings.NewReader(testHTML), &bytes.Buffer{}, file.StreamRules()...)
+		assert.True(t, errors.Is(err, stream.ErrNotFound))
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		content string
+		err     string
+	}{
+		{"unknown action", YAML, "rules:\n  - {selector: id=a, action: replace}", `rule 1: unknown action "replace"`},
+		{"missing selector", YAML, "rules:\n  - {action: remove}", "rule 1: missing selector"},
+		{"value and file", YAML, "rules:\n  - {selector: id=a, action: set, value: a, valueFile: a.html}", "rule 1: both value and valueFile are set"},
+		{"missing value file", YAML, "rules:\n  - {selector: id=a, action: set, valueFile: missing.html}", "rule 1: open"},
+		{"unknown onMissing", YAML, "rules:\n  - {selector: id=a, action: remove}\n  - {selector: id=b, action: remove, onMissing: skip}", `rule 2: unknown onMissing value "skip"`},
+		{"malformed selector", YAML, "rules:\n  - {selector: id=a, action: remove}\n  - {selector: banner, action: remove}", `rule 2: invalid path "banner"`},
+		{"attr without attribute", YAML, "rules:\n  - {selector: id=a, action: attr, value: b}", `rule 1: invalid attribute name ""`},
+		{"append without tag", YAML, "rules:\n  - {selector: id=a, action: append, value: b}", "rule 1: value must start with an html open tag '<'"},
+		{"lookahead selector", YAML, "rules:\n  - {selector: 'css=li:last-child', action: remove}", `rule 1: selector "li:last-child": :last-child needs to look ahead`},
+		{"unknown yaml field", YAML, "rules:\n  - {selector: id=a, action: remove, path: b}", "field path not found"},
+		{"unknown json field", JSON, `{"rules": [{"selector": "id=a", "action": "remove", "path": "b"}]}`, `unknown field "path"`},
+		{"invalid json", JSON, `{"rules": [`, "unexpected EOF"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.content), test.format, "testdata")
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, JSON, FormatOf("rules.JSON"))
+	assert.Equal(t, YAML, FormatOf("rules.yml"))
+	assert.Equal(t, YAML, FormatOf("rules"))
+}
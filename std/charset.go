@@ -0,0 +1,49 @@
+package std
+
+import (
+	"fmt"
+	"github.com/html-overwrite/internal/markup"
+	"golang.org/x/text/encoding"
+	"io"
+	"io/ioutil"
+)
+
+// read reads the document from r along with its encoding, which is
+// detected the same way the stream engine does and nil for utf-8.
+func (c Config) read(r io.Reader) ([]byte, encoding.Encoding, string, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	e, name := markup.DetectEncoding(src, c.ContentType)
+	return src, e, name, nil
+}
+
+// decode reads the document from r as utf-8 along with the encoding
+// it was in, nil for utf-8.
+func (c Config) decode(r io.Reader) ([]byte, encoding.Encoding, error) {
+	src, e, name, err := c.read(r)
+	if err != nil || e == nil {
+		return src, e, err
+	}
+
+	if src, err = e.NewDecoder().Bytes(src); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s document: %w", name, err)
+	}
+	return src, e, nil
+}
+
+// encode returns s in the given encoding, characters it lacks
+// are written as character references.
+func encode(e encoding.Encoding, s string) string {
+	if e == nil {
+		return s
+	}
+
+	encoded, err := encoding.HTMLEscapeUnsupported(e.NewEncoder()).String(s)
+	if err != nil {
+		return s
+	}
+	return encoded
+}
@@ -0,0 +1,14 @@
+package std
+
+// Config holds the options documents are queried with,
+// the package level functions use its zero value.
+type Config struct {
+	// FoldValues matches id and class values regardless
+	// of their case, names are always matched so.
+	FoldValues bool
+	// ContentType is the Content-Type header the html was
+	// served with, its charset takes precedence over the one
+	// declared by the html. Documents are output in their
+	// encoding, utf-8 unless declared otherwise.
+	ContentType string
+}
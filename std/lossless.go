@@ -0,0 +1,283 @@
+package std
+
+import (
+	"fmt"
+	"github.com/html-overwrite/internal/markup"
+	"github.com/html-overwrite/model"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"io"
+	"sort"
+	"strings"
+)
+
+// span locates an element in the source
+// by byte offsets.
+type span struct {
+	start        int // start of the start tag
+	contentStart int // end of the start tag
+	contentEnd   int // start of the end tag
+	end          int // end of the end tag
+}
+
+// patch replaces the source from start
+// to end with value.
+type patch struct {
+	start, end int
+	value      string
+}
+
+// losslessWriter edits the source of the document rather
+// than rendering its tree, so every byte outside of the
+// edited regions is kept as it was.
+type losslessWriter struct {
+	source   string
+	root     *html.Node
+	spans    map[*html.Node]span
+	config   Config
+	encoding encoding.Encoding // encoding of the source, nil for utf-8
+}
+
+// NewLosslessWriter loads the html read from r into a writer whose
+// String returns the original bytes with only the edited regions
+// changed. Unlike the default writer no html, head or body elements
+// are added and whitespace, quoting and doctypes are left alone.
+// Values are inserted as they are given, in the encoding of the document.
+// The writer is also a model.Locator, telling where elements are.
+func NewLosslessWriter(r io.Reader) (model.Editor, error) {
+	return Config{}.NewLosslessWriter(r)
+}
+
+// NewLosslessWriter works like the package level
+// NewLosslessWriter using the options of the config.
+func (c Config) NewLosslessWriter(r io.Reader) (model.Editor, error) {
+	source, e, name, err := c.read(r)
+	if err != nil {
+		return nil, err
+	}
+	if name == "utf-16be" || name == "utf-16le" {
+		return nil, fmt.Errorf("%s documents are not supported", name)
+	}
+
+	w := &losslessWriter{config: c, encoding: e}
+	w.load(string(source))
+	return w, nil
+}
+
+// load parses the source into a tree of its
+// elements along with their offsets.
+func (w *losslessWriter) load(source string) {
+	w.source = source
+	w.root = &html.Node{Type: html.DocumentNode}
+	w.spans = map[*html.Node]span{}
+
+	stack := []*html.Node{w.root}
+	// closeFrom closes the elements of the stack
+	// from index i up, implicitly ended at offset
+	closeFrom := func(i, offset int) {
+		for _, node := range stack[i:] {
+			s := w.spans[node]
+			s.contentEnd, s.end = offset, offset
+			w.spans[node] = s
+		}
+		stack = stack[:i]
+	}
+
+	z := html.NewTokenizer(strings.NewReader(source))
+	offset := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		start := offset
+		offset += len(z.Raw())
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			// elements whose end tag is optional are
+			// ended by the start tags of some others
+			for top := len(stack) - 1; top > 0 && markup.EndsOnStart(stack[top].Data, token.Data); top-- {
+				closeFrom(top, start)
+			}
+
+			node := &html.Node{Type: html.ElementNode, Data: token.Data, DataAtom: token.DataAtom, Attr: token.Attr}
+			stack[len(stack)-1].AppendChild(node)
+			w.spans[node] = span{start, offset, offset, offset}
+
+			// "/>" only ends foreign elements, html ones stay open
+			selfClosing := tt == html.SelfClosingTagToken && inForeignContent(stack, token.Data)
+			if !selfClosing && !markup.IsVoid(token.Data) {
+				stack = append(stack, node)
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Data != string(name) {
+					continue
+				}
+
+				closeFrom(i+1, start)
+				s := w.spans[stack[i]]
+				s.contentEnd, s.end = start, offset
+				w.spans[stack[i]] = s
+				stack = stack[:i]
+				break
+			}
+		}
+	}
+
+	closeFrom(1, offset)
+}
+
+// edit applies the patches of the nodes matching path, patches
+// within regions replaced by earlier ones are dropped since they
+// apply to nodes that are gone.
+func (w *losslessWriter) edit(path string, patchOf func(n *html.Node, s span) (patch, bool)) error {
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
+
+	patches := make([]patch, 0, len(nodes))
+	for _, node := range nodes {
+		if p, ok := patchOf(node, w.spans[node]); ok {
+			patches = append(patches, p)
+		}
+	}
+	if len(patches) == 0 {
+		// the matched elements can't hold content
+		n := nodes[0]
+		position := model.PositionOf(w.source, w.spans[n].start)
+		return fmt.Errorf("element %s matching %q at %v can't have content: %w", n.Data, path, position, model.ErrNotFound)
+	}
+	sort.SliceStable(patches, func(i, j int) bool {
+		return patches[i].start < patches[j].start
+	})
+
+	sb := &strings.Builder{}
+	offset := 0
+	for _, p := range patches {
+		if p.start < offset {
+			continue
+		}
+		sb.WriteString(w.source[offset:p.start])
+		sb.WriteString(encode(w.encoding, p.value))
+		offset = p.end
+	}
+	sb.WriteString(w.source[offset:])
+
+	w.load(sb.String())
+	return nil
+}
+
+// Set will query for nodes matching the
+// given path and set their content to be the
+// given value.
+func (w *losslessWriter) Set(path, value string) error {
+	return w.edit(path, func(n *html.Node, s span) (patch, bool) {
+		return patch{s.contentStart, s.contentEnd, value}, hasContent(n)
+	})
+}
+
+// Append will query for nodes matching the
+// given path and append a new child node
+// as the given value.
+func (w *losslessWriter) Append(path, value string) error {
+	return w.edit(path, func(n *html.Node, s span) (patch, bool) {
+		return patch{s.contentEnd, s.contentEnd, value}, hasContent(n)
+	})
+}
+
+// Prepend will query for nodes matching the
+// given path and prepend a new child node
+// as the given value.
+func (w *losslessWriter) Prepend(path, value string) error {
+	return w.edit(path, func(n *html.Node, s span) (patch, bool) {
+		return patch{s.contentStart, s.contentStart, value}, hasContent(n)
+	})
+}
+
+// Remove will query for nodes matching the
+// given path and remove them from the document.
+func (w *losslessWriter) Remove(path string) error {
+	return w.edit(path, func(n *html.Node, s span) (patch, bool) {
+		return patch{s.start, s.end, ""}, true
+	})
+}
+
+// SetAttr will query for nodes matching the
+// given path and set their attribute with the
+// given name to be the given value.
+func (w *losslessWriter) SetAttr(path, name, value string) error {
+	attr := name + `="` + html.EscapeString(value) + `"`
+	return w.edit(path, func(n *html.Node, s span) (patch, bool) {
+		tag := []byte(w.source[s.start:s.contentStart])
+		attrs := markup.ScanAttributes(tag)
+		insertAt := s.start + attrs.Pos
+		for attrs.Next() {
+			if strings.EqualFold(string(attrs.Attr.Key(tag)), name) {
+				return patch{s.start + attrs.Attr.KeyStart, s.start + attrs.Attr.End, attr}, true
+			}
+			insertAt = s.start + attrs.Attr.End
+		}
+		return patch{insertAt, insertAt, " " + attr}, true
+	})
+}
+
+// Locate returns the positions of the start tags of the elements
+// matching the given path in the source with the edits applied.
+func (w *losslessWriter) Locate(path string) ([]model.Position, error) {
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// elements matched by several matchers are located once
+	offsets := make([]int, 0, len(nodes))
+	seen := map[*html.Node]bool{}
+	for _, node := range nodes {
+		if !seen[node] {
+			seen[node] = true
+			offsets = append(offsets, w.spans[node].start)
+		}
+	}
+	sort.Ints(offsets)
+
+	positions := make([]model.Position, len(offsets))
+	p := model.StartPosition
+	for i, offset := range offsets {
+		p = p.Advance([]byte(w.source[p.Offset:offset]))
+		positions[i] = p
+	}
+	return positions, nil
+}
+
+// String will return the source of
+// the document with the edits applied.
+func (w *losslessWriter) String() string {
+	return w.source
+}
+
+// inForeignContent reports whether the element with the given name
+// is or is nested in a foreign element of the stack.
+func inForeignContent(stack []*html.Node, name string) bool {
+	if markup.IsForeign(name) {
+		return true
+	}
+	for _, node := range stack {
+		if markup.IsForeign(node.Data) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasContent reports whether the element
+// can have content, which void ones can't.
+func hasContent(n *html.Node) bool {
+	return !markup.IsVoid(n.Data)
+}
@@ -2,7 +2,10 @@ package std
 
 import (
 	"bytes"
+	"fmt"
+	"github.com/html-overwrite/model"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"io"
 	"strings"
 )
@@ -20,6 +23,19 @@ func removeNodeChildren(node *html.Node) {
 	}
 }
 
+// setAttr sets the attribute of the given node with the
+// given key, adding it when missing. Keys are case-insensitive.
+func setAttr(n *html.Node, key, val string) {
+	key = strings.ToLower(key)
+	for i := range n.Attr {
+		if n.Attr[i].Key == key && n.Attr[i].Namespace == "" {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
 // renderNode will convert the given HTML node
 // to a string format.
 func renderNode(n *html.Node) string {
@@ -68,15 +84,65 @@ func cloneNode(n *html.Node) *html.Node {
 // parsePartial parses a given value as a partial HTML
 // format string.
 func parsePartial(value string) (*html.Node, error) {
-	node, err := html.Parse(strings.NewReader(value))
+	nodes, err := parseFragment(strings.NewReader(value))
 	if err != nil {
 		return nil, err
 	}
 
-	child := node.LastChild.LastChild.LastChild
-	child.Parent = nil
-	child.PrevSibling = nil
-	child.NextSibling = nil
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no html found in %q", value)
+	}
+
+	return nodes[len(nodes)-1], nil
+}
+
+// parseFragment parses partial html, the content of a template
+// element is used as context since it accepts any element.
+func parseFragment(r io.Reader) ([]*html.Node, error) {
+	return html.ParseFragment(r, &html.Node{
+		Type:     html.ElementNode,
+		Data:     "template",
+		DataAtom: atom.Template,
+	})
+}
+
+// fragmentNodes parses the partial html of a fragment writer. The
+// fragment parser drops doctypes, so a leading one is kept aside,
+// and html, head and body tags, which are rejected instead.
+func fragmentNodes(src []byte) ([]*html.Node, error) {
+	var doctype *html.Node
+	start, offset := 0, 0
+	z := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			break
+		}
 
-	return child, nil
+		switch tt {
+		case html.DoctypeToken:
+			if doctype != nil || len(bytes.TrimSpace(src[:offset])) > 0 {
+				return nil, fmt.Errorf("doctype at %v doesn't start the html", model.PositionOf(string(src), offset))
+			}
+			doctype = &html.Node{Type: html.DoctypeNode, Data: z.Token().Data}
+			start = offset + len(z.Raw())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch a := atom.Lookup(name); a {
+			case atom.Html, atom.Head, atom.Body:
+				return nil, fmt.Errorf("%s tag at %v can't be kept in partial html, load it as a document instead",
+					a, model.PositionOf(string(src), offset))
+			}
+		}
+		offset += len(z.Raw())
+	}
+
+	nodes, err := parseFragment(bytes.NewReader(src[start:]))
+	if err != nil || doctype == nil {
+		return nodes, err
+	}
+	return append([]*html.Node{doctype}, nodes...), nil
 }
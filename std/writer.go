@@ -1,9 +1,11 @@
 package std
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/html-overwrite/model"
 	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
 	"io"
 	"strings"
 )
@@ -12,18 +14,20 @@ import (
 // implementation of the Writer
 // interface.
 type writer struct {
-	root *html.Node
+	root     *html.Node
+	config   Config
+	encoding encoding.Encoding // encoding of the document, nil for utf-8
 }
 
 // a query will return a list of found nodes
 // based on a given path
 // PATH FORMAT:
 // variables consist of passing either
-// id/class an equals sign and their
+// id/class/tag an equals sign and their
 // respective value split up by a comma.
 // Example:
 // class=name-of-class,id=3
-func (w *writer) query(path string) (res []*html.Node) {
+func (c Config) query(root *html.Node, path string) (res []*html.Node) {
 	res = make([]*html.Node, 0)
 	items := strings.Split(path, ",")
 	for _, item := range items {
@@ -31,37 +35,56 @@ func (w *writer) query(path string) (res []*html.Node) {
 		k, v := kv[0], kv[1]
 		switch k {
 		case "id":
-			if n, err := id(w.root, v); err == nil {
+			if n, err := id(root, v, c.FoldValues); err == nil {
 				res = append(res, n)
 				return
 			}
 
 		case "class":
-			nodes := filter(w.root, func(n *html.Node) bool {
+			nodes := filter(root, func(n *html.Node) bool {
 				for _, attr := range n.Attr {
 					if attr.Key == "class" {
+						if c.FoldValues {
+							return strings.Contains(strings.ToLower(attr.Val), strings.ToLower(v))
+						}
 						return strings.Contains(attr.Val, v)
 					}
 				}
 				return false
 			})
 			res = append(res, nodes...)
+
+		case "tag":
+			nodes := filter(root, func(n *html.Node) bool {
+				return n.Type == html.ElementNode && strings.EqualFold(n.Data, v)
+			})
+			res = append(res, nodes...)
 		}
 
 	}
 	return
 }
 
+// queryAll runs the query and fails when
+// no node matched the given path.
+func (c Config) queryAll(root *html.Node, path string) ([]*html.Node, error) {
+	nodes := c.query(root, path)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("element matching %q was not found: %w", path, model.ErrNotFound)
+	}
+	return nodes, nil
+}
+
 // find the first node in the root tree matching the
-// id criteria.
-func id(root *html.Node, id string) (*html.Node, error) {
+// id criteria, ignoring its case if fold is set.
+func id(root *html.Node, id string, fold bool) (*html.Node, error) {
 	var found *html.Node
 	var crawler func(*html.Node)
 	crawler = func(node *html.Node) {
 		for _, attr := range node.Attr {
 			// check if attr is "id" and if its value
 			// matches our given id value
-			if attr.Key == "id" && attr.Val == id {
+			if attr.Key == "id" && (attr.Val == id || fold && strings.EqualFold(attr.Val, id)) {
 				found = node
 				return
 			}
@@ -100,7 +123,10 @@ func filter(root *html.Node, run func(n *html.Node) bool) []*html.Node {
 // given path and set their content to be the
 // given value.
 func (w *writer) Set(path, value string) (err error) {
-	nodes := w.query(path)
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
 
 	// parse value as html node
 	var newNode *html.Node
@@ -133,7 +159,10 @@ func appendChild(node *html.Node, child *html.Node) {
 // given path and append a new child node
 // as the given value.
 func (w *writer) Append(path, value string) (err error) {
-	nodes := w.query(path)
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
 
 	// parse value as html node
 	var newNode *html.Node
@@ -148,17 +177,112 @@ func (w *writer) Append(path, value string) (err error) {
 	return
 }
 
+// Prepend will query for nodes matching the
+// given path and prepend a new child node
+// as the given value.
+func (w *writer) Prepend(path, value string) (err error) {
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
+
+	// parse value as html node
+	var newNode *html.Node
+	if newNode, err = parsePartial(value); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		node.InsertBefore(cloneNode(newNode), node.FirstChild)
+	}
+
+	return
+}
+
+// Remove will query for nodes matching the
+// given path and remove them from the document.
+func (w *writer) Remove(path string) error {
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		// nodes nested in already removed
+		// nodes are detached by now
+		if node.Parent != nil {
+			node.Parent.RemoveChild(node)
+		}
+	}
+	return nil
+}
+
+// SetAttr will query for nodes matching the
+// given path and set their attribute with the
+// given name to be the given value.
+func (w *writer) SetAttr(path, name, value string) error {
+	nodes, err := w.config.queryAll(w.root, path)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		setAttr(node, name, value)
+	}
+	return nil
+}
+
 // String will return the active HTML node
 // loaded into the writer in a string format.
 func (w *writer) String() string {
-	return renderNode(w.root)
+	return encode(w.encoding, renderNode(w.root))
+}
+
+func NewWriter(r io.Reader) (model.Editor, error) {
+	return Config{}.NewWriter(r)
+}
+
+// NewFragmentWriter loads partial html into a writer,
+// unlike NewWriter no html, head or body elements are
+// added around it on output. Partial html holding html,
+// head or body tags is rejected, since they'd be lost.
+func NewFragmentWriter(r io.Reader) (model.Editor, error) {
+	return Config{}.NewFragmentWriter(r)
 }
 
-func NewWriter(r io.Reader) (model.Writer, error) {
-	doc, err := html.Parse(r)
+// NewWriter works like the package level NewWriter
+// using the options of the config.
+func (c Config) NewWriter(r io.Reader) (model.Editor, error) {
+	src, e, err := c.decode(r)
 	if err != nil {
 		return nil, err
 	}
 
-	return &writer{doc}, nil
+	doc, err := html.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{doc, c, e}, nil
+}
+
+// NewFragmentWriter works like the package level
+// NewFragmentWriter using the options of the config.
+func (c Config) NewFragmentWriter(r io.Reader) (model.Editor, error) {
+	src, e, err := c.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := fragmentNodes(src)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &html.Node{Type: html.DocumentNode}
+	for _, node := range nodes {
+		root.AppendChild(node)
+	}
+
+	return &writer{root, c, e}, nil
 }
@@ -0,0 +1,31 @@
+package stream
+
+import "github.com/html-overwrite/internal/markup"
+
+// hasClass reports whether the whitespace
+// separated classes hold the given one.
+func hasClass(classes []byte, class string, fold bool) bool {
+	for len(classes) > 0 {
+		i := 0
+		for i < len(classes) && markup.IsSpace(classes[i]) {
+			i++
+		}
+		j := i
+		for j < len(classes) && !markup.IsSpace(classes[j]) {
+			j++
+		}
+		if j > i && equalValue(classes[i:j], class, fold) {
+			return true
+		}
+		classes = classes[j:]
+	}
+	return false
+}
+
+// equalValue compares an attribute value, folding its case if fold is set.
+func equalValue(value []byte, s string, fold bool) bool {
+	if fold {
+		return equalFold(value, s)
+	}
+	return string(value) == s
+}
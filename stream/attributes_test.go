@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		rule     Rule
+		expected string
+	}{
+		{
+			name:     "class list",
+			body:     `<div class="a b c">text</div>`,
+			rule:     SetRule("class=b", "new"),
+			expected: `<div class="a b c">new</div>`,
+		},
+		{
+			name:     "class prefix",
+			body:     `<div class="ab">one</div><div class="a">two</div>`,
+			rule:     SetRule("class=a", "new"),
+			expected: `<div class="ab">one</div><div class="a">new</div>`,
+		},
+		{
+			name:     "single quoted",
+			body:     `<div id='a'>text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id='a'>new</div>`,
+		},
+		{
+			name:     "unquoted",
+			body:     `<div id=a>text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id=a>new</div>`,
+		},
+		{
+			name:     "spaces around equals",
+			body:     `<div id = "a">text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id = "a">new</div>`,
+		},
+		{
+			name:     "newlines and tabs",
+			body:     "<div\n\tclass=\"x\"\n\tid=\"a\"\n>text</div>",
+			rule:     SetRule("id=a", "new"),
+			expected: "<div\n\tclass=\"x\"\n\tid=\"a\"\n>new</div>",
+		},
+		{
+			name:     "greater than in quoted value",
+			body:     `<div title="a > b" id="a">text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div title="a > b" id="a">new</div>`,
+		},
+		{
+			name:     "value of another attribute",
+			body:     `<div title="id=a">one</div><div id="a">two</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div title="id=a">one</div><div id="a">new</div>`,
+		},
+		{
+			name:     "attribute without value",
+			body:     `<input id="a" disabled><p>text</p>`,
+			rule:     AttrRule("id=a", "disabled", "disabled"),
+			expected: `<input id="a" disabled="disabled"><p>text</p>`,
+		},
+		{
+			name:     "replace single quoted",
+			body:     `<a href='/old' id=a>link</a>`,
+			rule:     AttrRule("id=a", "href", "/new"),
+			expected: `<a href="/new" id=a>link</a>`,
+		},
+		{
+			name:     "replace unquoted",
+			body:     `<a id=a href=/old>link</a>`,
+			rule:     AttrRule("id=a", "href", "/new"),
+			expected: `<a id=a href="/new">link</a>`,
+		},
+		{
+			name:     "add after quoted greater than",
+			body:     `<div title="a > b" id="a">text</div>`,
+			rule:     AttrRule("id=a", "class", "c"),
+			expected: `<div title="a > b" id="a" class="c">text</div>`,
+		},
+		{
+			name:     "add to self closing",
+			body:     `<div id="a"><br class="x"/></div>`,
+			rule:     AttrRule("class=x", "id", "b"),
+			expected: `<div id="a"><br class="x" id="b"/></div>`,
+		},
+		{
+			name:     "uppercase tag",
+			body:     `<DIV>text</DIV>`,
+			rule:     SetRule("tag=div", "new"),
+			expected: `<DIV>new</DIV>`,
+		},
+		{
+			name:     "uppercase path",
+			body:     `<div>text</div>`,
+			rule:     SetRule("tag=DIV", "new"),
+			expected: `<div>new</div>`,
+		},
+		{
+			name:     "uppercase attribute name",
+			body:     `<div ID="a" Class="b">text</div>`,
+			rule:     AppendRule("class=b", "<b>!</b>"),
+			expected: `<div ID="a" Class="b">text<b>!</b></div>`,
+		},
+		{
+			name:     "case sensitive value",
+			body:     `<div id="A">one</div><div id="a">two</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id="A">one</div><div id="a">new</div>`,
+		},
+		{
+			name:     "replace uppercase attribute",
+			body:     `<a ID="a" HREF="/old">link</a>`,
+			rule:     AttrRule("id=a", "href", "/new"),
+			expected: `<a ID="a" href="/new">link</a>`,
+		},
+		{
+			name:     "uppercase raw text",
+			body:     `<SCRIPT>"<div id='a'>"</SCRIPT><div id="a">text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<SCRIPT>"<div id='a'>"</SCRIPT><div id="a">new</div>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			err := Rewrite(strings.NewReader(fmt.Sprintf(testSetHtmlTemplate, test.body)), buffer, test.rule)
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf(testSetHtmlTemplate, test.expected), buffer.String())
+		})
+	}
+
+	t.Run("no allocations", func(t *testing.T) {
+		tag := []byte(`<div title="a > b" class='x y' id=z>`)
+		allocs := testing.AllocsPerRun(100, func() {
+			matchTag(tag, tagName(tag), "class=y", false)
+			tokenLength(tag, false)
+		})
+		assert.Zero(t, allocs)
+	})
+}
@@ -0,0 +1,15 @@
+package stream
+
+import (
+	"github.com/html-overwrite/internal/markup"
+	"golang.org/x/text/encoding"
+)
+
+// DetectEncoding determines the encoding of the document starting
+// with b the way browsers do: from its byte order mark, the charset
+// of the Content-Type it was served with or the one its meta tags
+// declare, in this order. The encoding is nil for utf-8 documents,
+// which documents declaring none are taken as.
+func DetectEncoding(b []byte, contentType string) (encoding.Encoding, string) {
+	return markup.DetectEncoding(b, contentType)
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/japanese"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncodedValues(t *testing.T) {
+	t.Run("windows-1252", func(t *testing.T) {
+		const document = `<html><head><meta charset="windows-1252"></head><body><p id="a">caf` + "\xe9" + `</p></body></html>`
+
+		buffer := &bytes.Buffer{}
+		err := Rewrite(iotest.OneByteReader(strings.NewReader(document)), buffer,
+			SetRule("id=a", "thé"),
+			AttrRule("id=a", "title", "é & ✓"),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, `<html><head><meta charset="windows-1252"></head><body><p id="a" title="`+"\xe9"+` &amp; &#10003;">th`+"\xe9"+`</p></body></html>`, buffer.String())
+	})
+
+	t.Run("shift_jis", func(t *testing.T) {
+		encoded, err := japanese.ShiftJIS.NewEncoder().String("日本語")
+		assert.Nil(t, err)
+		document := `<html><head></head><body><p id="a">` + encoded + `</p></body></html>`
+
+		buffer := &bytes.Buffer{}
+		config := Config{ContentType: "text/html; charset=shift_jis"}
+		assert.Nil(t, config.Rewrite(strings.NewReader(document), buffer, AppendRule("id=a", "<b>語</b>")))
+
+		decoded, err := japanese.ShiftJIS.NewDecoder().String(buffer.String())
+		assert.Nil(t, err)
+		assert.Equal(t, `<html><head></head><body><p id="a">日本語<b>語</b></p></body></html>`, decoded)
+	})
+
+	t.Run("undeclared", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		err := Rewrite(strings.NewReader(`<html><body><p id="a"></p></body></html>`), buffer, SetRule("id=a", "thé"))
+		assert.Nil(t, err)
+		assert.Equal(t, `<html><body><p id="a">thé</p></body></html>`, buffer.String())
+	})
+
+	t.Run("utf-16", func(t *testing.T) {
+		err := Rewrite(strings.NewReader("\xff\xfe<\x00h\x00"), &bytes.Buffer{}, SetRule("id=a", "text"))
+		assert.EqualError(t, err, "utf-16le documents are not supported")
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		w := NewWriter(buffer, SetRule("id=a", "é"))
+		for _, chunk := range []string{`<html><head>`, `<meta charset="latin1">`, `</head><body><p id="a"></p></body></html>`} {
+			_, err := w.Write([]byte(chunk))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, w.Close())
+		assert.Equal(t, `<html><head><meta charset="latin1"></head><body><p id="a">`+"\xe9"+`</p></body></html>`, buffer.String())
+	})
+
+	t.Run("content type charset", func(t *testing.T) {
+		for contentType, held := range map[string]bool{
+			"text/html; charset=utf-8": false,
+			"text/html":                true,
+		} {
+			buffer := &bytes.Buffer{}
+			w := Config{ContentType: contentType}.NewWriter(buffer, SetRule("id=a", "b"))
+			_, err := w.Write([]byte(`<html><head><title>a</title><link rel="icon" href="/a.ico">`))
+			assert.Nil(t, err)
+			// the encoding is known without the head ending
+			assert.Equal(t, held, buffer.Len() == 0, contentType)
+
+			_, err = w.Write([]byte(`</head><body><p id="a"></p></body></html>`))
+			assert.Nil(t, err)
+			assert.Nil(t, w.Close())
+			assert.Equal(t, `<html><head><title>a</title><link rel="icon" href="/a.ico"></head><body><p id="a">b</p></body></html>`, buffer.String())
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package stream
+
+import "io"
+
+// Config holds the options html is parsed with, the
+// package level functions use its zero value.
+type Config struct {
+	// Fragment treats the input as partial html.
+	//
+	// Deprecated: elements are matched from the first tag of
+	// any input on, documents without an html, head or body
+	// tag and fragments alike.
+	Fragment bool
+	// FoldValues matches id and class values regardless
+	// of their ASCII case, names are always matched so.
+	FoldValues bool
+	// ContentType is the Content-Type header the html was
+	// served with, its charset takes precedence over the one
+	// declared by the html. Rule values are encoded in the
+	// encoding of the html, utf-8 unless declared otherwise.
+	ContentType string
+	// Handler is called back with the
+	// tokens of the html as it is rewritten.
+	Handler *Handler
+}
+
+// Rewrite works like the package level Rewrite
+// using the options of the config.
+func (c Config) Rewrite(r io.Reader, w io.Writer, rules ...Rule) error {
+	pc := defaultPool.Get(r, w)
+	defer defaultPool.Put(pc)
+
+	pc.setup(c, rules)
+	for !pc.step() {
+	}
+
+	return pc.result()
+}
+
+// RewriteMatches works like the package level
+// RewriteMatches using the options of the config.
+func (c Config) RewriteMatches(r io.Reader, w io.Writer, rules ...Rule) ([]Match, error) {
+	pc := defaultPool.Get(r, w)
+	defer defaultPool.Put(pc)
+
+	pc.setup(c, rules)
+	for !pc.step() {
+	}
+
+	matches := make([]Match, len(pc.matches))
+	for i, m := range pc.matches {
+		matches[i] = Match{Matched: m.matched, Position: m.position}
+	}
+
+	return matches, pc.result()
+}
+
+// NewReader works like the package level NewReader
+// using the options of the config.
+func (c Config) NewReader(src io.Reader, rules ...Rule) io.ReadCloser {
+	rd := &reader{src: src}
+	rd.pc = defaultPool.Get(src, &rd.out)
+	rd.pc.setup(c, rules)
+	return rd
+}
+
+// NewWriter works like the package level NewWriter
+// using the options of the config.
+func (c Config) NewWriter(dst io.Writer, rules ...Rule) io.WriteCloser {
+	wr := &writer{pc: defaultPool.Get(nil, dst)}
+	wr.pc.setup(c, rules)
+	return wr
+}
@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"github.com/html-overwrite/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFragment(t *testing.T) {
+	const fragment = `<div id="card"><p class="title">old</p></div><p>rest</p>`
+	const expected = `<div id="card"><p class="title">new</p></div><p>rest</p>`
+	rule := SetRule("class=title", "new")
+	config := Config{Fragment: true}
+
+	t.Run("rewrite", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		assert.Nil(t, config.Rewrite(strings.NewReader(fragment), buffer, rule))
+		assert.Equal(t, expected, buffer.String())
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		out, err := ioutil.ReadAll(config.NewReader(strings.NewReader(fragment), rule))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(out))
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		w := config.NewWriter(buffer, rule)
+		_, err := w.Write([]byte(fragment))
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+		assert.Equal(t, expected, buffer.String())
+	})
+
+	t.Run("matches", func(t *testing.T) {
+		matches, err := config.RewriteMatches(strings.NewReader(fragment), ioutil.Discard, rule, RemoveRule("id=missing"))
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Equal(t, []Match{{Matched: true, Position: model.Position{Offset: 15, Line: 1, Column: 16}}, {}}, matches)
+	})
+
+	t.Run("without the option", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		assert.Nil(t, Rewrite(strings.NewReader(fragment), buffer, rule))
+		assert.Equal(t, expected, buffer.String())
+	})
+
+	t.Run("doctype", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		err := config.Rewrite(strings.NewReader("<!DOCTYPE html>\n"+fragment), buffer, rule)
+		assert.Nil(t, err)
+		assert.Equal(t, "<!DOCTYPE html>\n"+expected, buffer.String())
+	})
+}
+
+func TestDocumentStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{name: "doctype and body", document: `<!DOCTYPE html><body><div id=x>a</div>`},
+		{name: "comment", document: "<!-- page -->\n<div id=x>a</div>"},
+		{name: "table", document: `<table><tr><td id=x>a</td></tr></table>`},
+		{name: "paragraph", document: `<p id=x>a</p>`},
+		{name: "header", document: `<header><p id=x>a</p></header>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			assert.Nil(t, Rewrite(strings.NewReader(test.document), buffer, SetRule("id=x", "b")))
+			assert.Equal(t, strings.Replace(test.document, ">a<", ">b<", 1), buffer.String())
+		})
+	}
+}
+
+func TestFoldValues(t *testing.T) {
+	const document = `<Html><Head></Head><Body><div id="Card" class="Title Main">old</div></Body></Html>`
+	config := Config{FoldValues: true}
+
+	for _, path := range []string{"id=card", "class=title", "class=MAIN", "tag=DIV"} {
+		t.Run(path, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			assert.Nil(t, config.Rewrite(strings.NewReader(document), buffer, SetRule(path, "new")))
+			assert.Equal(t, strings.Replace(document, "old", "new", 1), buffer.String())
+
+			if path != "tag=DIV" {
+				err := Rewrite(strings.NewReader(document), ioutil.Discard, SetRule(path, "new"))
+				assert.True(t, errors.Is(err, ErrNotFound))
+			}
+		})
+	}
+}
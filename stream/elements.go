@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"github.com/html-overwrite/internal/markup"
+	"golang.org/x/net/html/atom"
+)
+
+// element is an open element. Elements of known names are told
+// apart by their atom, the others by their lowercased name, which
+// the parse context keeps in its names rather than holding on to
+// the buffer their tags were read into.
+type element struct {
+	atom atom.Atom
+	kind elementKind
+	// names is the length of the names of the
+	// parse context once the element was opened
+	names int
+}
+
+// elementKind holds the properties of the elements of a name.
+type elementKind uint8
+
+const (
+	// voidElement has no content and no end tag.
+	voidElement elementKind = 1 << iota
+	// textElement holds text only, which lasts until its end tag.
+	textElement
+	// optionalEnd is ended by the end of the input.
+	optionalEnd
+	// foreignElement holds content whose elements may be self-closing.
+	foreignElement
+	// impliedEnd is ended by the start tags of some other elements.
+	impliedEnd
+)
+
+// maxNameLength is longer than the name of any atom,
+// longer names are known to have none.
+const maxNameLength = 32
+
+// lookupName returns the atom of the name regardless
+// of its case, 0 if it is the name of no atom.
+func lookupName(name []byte) atom.Atom {
+	if len(name) > maxNameLength {
+		return 0
+	}
+	var lower [maxNameLength]byte
+	for i, c := range name {
+		lower[i] = lowerASCII(c)
+	}
+	return atom.Lookup(lower[:len(name)])
+}
+
+func atomOf(name string) atom.Atom {
+	a := atom.Lookup([]byte(name))
+	if a == 0 {
+		panic("stream: no atom for element " + name)
+	}
+	return a
+}
+
+// elementKinds are the properties of the known elements.
+var elementKinds = func() map[atom.Atom]elementKind {
+	kinds := map[atom.Atom]elementKind{}
+	for kind, names := range map[elementKind][]string{
+		voidElement:    markup.VoidElements,
+		textElement:    markup.TextElements,
+		optionalEnd:    markup.OptionalEnds,
+		foreignElement: markup.ForeignElements,
+	} {
+		for _, name := range names {
+			kinds[atomOf(name)] |= kind
+		}
+	}
+	for open := range markup.ImpliedEnds {
+		kinds[atomOf(open)] |= impliedEnd
+	}
+	return kinds
+}()
+
+// impliedEnds holds the pairs of elements whose end tag may be
+// omitted and of the start tags that implicitly end them.
+var impliedEnds = func() map[[2]atom.Atom]bool {
+	m := map[[2]atom.Atom]bool{}
+	for open, next := range markup.ImpliedEnds {
+		for _, name := range next {
+			m[[2]atom.Atom{atomOf(open), atomOf(name)}] = true
+		}
+	}
+	return m
+}()
+
+// kindOf returns the properties of the element.
+func kindOf(a atom.Atom) elementKind {
+	if a == 0 {
+		return 0
+	}
+	return elementKinds[a]
+}
+
+// push opens an element with the given name, atom and kind.
+func (pc *parseContext) push(name []byte, a atom.Atom, kind elementKind) {
+	if a == 0 {
+		for _, c := range name {
+			pc.names = append(pc.names, lowerASCII(c))
+		}
+	}
+	pc.stack = append(pc.stack, element{atom: a, kind: kind, names: len(pc.names)})
+}
+
+// pop closes the open elements from the given depth up.
+func (pc *parseContext) pop(depth int) {
+	pc.stack = pc.stack[:depth]
+	pc.names = pc.names[:pc.namesAt(depth)]
+}
+
+// namesAt returns where the name of the element
+// opened at the given depth starts in names.
+func (pc *parseContext) namesAt(depth int) int {
+	if depth == 0 {
+		return 0
+	}
+	return pc.stack[depth-1].names
+}
+
+// is reports whether the element open at the given
+// depth has the given name, whose atom is a.
+func (pc *parseContext) is(depth int, name []byte, a atom.Atom) bool {
+	e := pc.stack[depth]
+	if e.atom != 0 || a != 0 {
+		return e.atom == a
+	}
+	lower := pc.names[pc.namesAt(depth):e.names]
+	if len(name) != len(lower) {
+		return false
+	}
+	for i, c := range name {
+		if lowerASCII(c) != lower[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"github.com/html-overwrite/internal/markup"
+	"github.com/html-overwrite/model"
+)
+
+// TokenType is the type of a Token.
+type TokenType uint8
+
+const (
+	// TextToken is text, including the content of
+	// script, style and the other text elements.
+	TextToken TokenType = iota
+	// StartTagToken is a start tag such as <div id="a">.
+	StartTagToken
+	// EndTagToken is an end tag such as </div>.
+	EndTagToken
+	// CommentToken is a comment such as <!-- a -->.
+	CommentToken
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case TextToken:
+		return "text"
+	case StartTagToken:
+		return "start tag"
+	case EndTagToken:
+		return "end tag"
+	case CommentToken:
+		return "comment"
+	default:
+		return "unknown"
+	}
+}
+
+// Handler is called back with the tokens of the html as it is
+// rewritten, allowing transforms beyond the rules. Tokens within
+// the content replaced or removed by the rules aren't handed over,
+// nor are doctypes, CDATA sections and processing instructions.
+// Any of the callbacks may be nil.
+type Handler struct {
+	OnStartTag func(t *Token)
+	OnEndTag   func(t *Token)
+	// OnText may be called several times for one run
+	// of text since it is handed over as it is read.
+	OnText    func(t *Token)
+	OnComment func(t *Token)
+}
+
+// Token is a token handed over to a Handler. The token and its bytes
+// are reused once the callback returns, so neither may be kept.
+type Token struct {
+	Type     TokenType
+	Raw      []byte         // the token as found in the input
+	Position model.Position // where the token starts in the input
+
+	pc          *parseContext
+	start       int // position of the token within the buffer
+	replaced    bool
+	replacement []byte
+}
+
+// Name returns the name of a start or end tag as it is
+// written, nil is returned for the other token types.
+func (t *Token) Name() []byte {
+	switch t.Type {
+	case StartTagToken:
+		return tagName(t.Raw)
+	case EndTagToken:
+		return endTagName(t.Raw)
+	default:
+		return nil
+	}
+}
+
+// Attr returns the value of the attribute of a start tag with the
+// given name, which is matched regardless of its case. The value is
+// returned as it is written, with character references left alone.
+func (t *Token) Attr(name string) ([]byte, bool) {
+	if t.Type != StartTagToken {
+		return nil, false
+	}
+
+	attrs := markup.ScanAttributes(t.Raw)
+	for attrs.Next() {
+		if equalFold(attrs.Attr.Key(t.Raw), name) {
+			return attrs.Attr.Value(t.Raw), true
+		}
+	}
+	return nil, false
+}
+
+// Replace writes b in place of the token once the callback returns,
+// b is kept until then. Start tags that are replaced aren't matched
+// against the rules, their elements are still tracked though.
+func (t *Token) Replace(b []byte) {
+	t.replaced = true
+	t.replacement = b
+}
+
+// Suppress drops the token from the output.
+func (t *Token) Suppress() {
+	t.Replace(nil)
+}
+
+// Emit writes b to the output right away, before the token.
+func (t *Token) Emit(b []byte) {
+	t.pc.flush(t.start)
+	t.pc.output(b)
+}
+
+// dispatch hands the given token starting at pos over to the handler
+// and replaces it as asked, it reports whether the token was replaced.
+// tokens whose bytes were already dropped or written aren't handed over.
+func (pc *parseContext) dispatch(typ TokenType, raw []byte) bool {
+	h := pc.config.Handler
+	if h == nil || pc.skipWrite || pc.written > pc.pos {
+		return false
+	}
+
+	var callback func(t *Token)
+	switch typ {
+	case TextToken:
+		callback = h.OnText
+	case StartTagToken:
+		callback = h.OnStartTag
+	case EndTagToken:
+		callback = h.OnEndTag
+	case CommentToken:
+		callback = h.OnComment
+	}
+	if callback == nil {
+		return false
+	}
+
+	pc.event = Token{Type: typ, Raw: raw, Position: pc.at(), pc: pc, start: pc.pos}
+	callback(&pc.event)
+	if !pc.event.replaced {
+		return false
+	}
+
+	pc.flush(pc.pos)
+	pc.output(pc.event.replacement)
+	pc.discard(pc.pos + len(raw))
+	pc.event.replacement = nil
+	return true
+}
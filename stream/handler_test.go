@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestHandler(t *testing.T) {
+	const document = "<html><body>\n<!-- note --><p id=\"a\">one <b>two</b></p><script>if (a < b) {}</script></body></html>"
+
+	t.Run("events", func(t *testing.T) {
+		var events []string
+		record := func(t *Token) {
+			events = append(events, fmt.Sprintf("%v %s %q %v", t.Type, t.Name(), t.Raw, t.Position))
+		}
+
+		h := &Handler{OnStartTag: record, OnEndTag: record, OnText: record, OnComment: record}
+		assert.Nil(t, Config{Handler: h}.Rewrite(strings.NewReader(document), io.Discard))
+		assert.Equal(t, []string{
+			`start tag html "<html>" 1:1`,
+			`start tag body "<body>" 1:7`,
+			`text  "\n" 1:13`,
+			`comment  "<!-- note -->" 2:1`,
+			`start tag p "<p id=\"a\">" 2:14`,
+			`text  "one " 2:24`,
+			`start tag b "<b>" 2:28`,
+			`text  "two" 2:31`,
+			`end tag b "</b>" 2:34`,
+			`end tag p "</p>" 2:38`,
+			`start tag script "<script>" 2:42`,
+			`text  "if (a " 2:50`,
+			`text  "< b) {}" 2:56`,
+			`end tag script "</script>" 2:63`,
+			`end tag body "</body>" 2:72`,
+			`end tag html "</html>" 2:79`,
+		}, events)
+	})
+
+	t.Run("transform", func(t *testing.T) {
+		h := &Handler{
+			OnStartTag: func(t *Token) {
+				if id, ok := t.Attr("ID"); ok {
+					t.Replace([]byte(`<p class="` + string(id) + `">`))
+				}
+				if string(t.Name()) == "b" {
+					t.Replace([]byte("<strong>"))
+				}
+			},
+			OnEndTag: func(t *Token) {
+				switch string(t.Name()) {
+				case "b":
+					t.Replace([]byte("</strong>"))
+				case "body":
+					t.Emit([]byte("<footer></footer>"))
+				}
+			},
+			OnText: func(t *Token) {
+				t.Replace(bytes.ToUpper(t.Raw))
+			},
+			OnComment: func(t *Token) {
+				t.Suppress()
+			},
+		}
+
+		for _, read := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader} {
+			buffer := &bytes.Buffer{}
+			assert.Nil(t, Config{Handler: h}.Rewrite(read(strings.NewReader(document)), buffer))
+			assert.Equal(t, "<html><body>\n<p class=\"a\">ONE <strong>TWO</strong></p><script>IF (A < B) {}</script><footer></footer></body></html>", buffer.String())
+		}
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		var texts []string
+		h := &Handler{
+			OnText: func(t *Token) {
+				texts = append(texts, string(t.Raw))
+			},
+			OnEndTag: func(t *Token) {
+				if string(t.Name()) == "p" {
+					t.Replace([]byte("</p><hr>"))
+				}
+			},
+		}
+
+		buffer := &bytes.Buffer{}
+		err := Config{Handler: h}.Rewrite(strings.NewReader(document), buffer,
+			SetRule("tag=b", "new"),
+			AppendRule("id=a", "<i>!</i>"),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, "<html><body>\n<!-- note --><p id=\"a\">one <b>new</b><i>!</i></p><hr><script>if (a < b) {}</script></body></html>", buffer.String())
+		assert.Equal(t, []string{"\n", "one ", "if (a ", "< b) {}"}, texts)
+	})
+
+	t.Run("replaced start tags aren't matched", func(t *testing.T) {
+		h := &Handler{
+			OnStartTag: func(t *Token) {
+				if _, ok := t.Attr("id"); ok {
+					t.Replace([]byte("<p>"))
+				}
+			},
+		}
+
+		buffer := &bytes.Buffer{}
+		err := Config{Handler: h}.Rewrite(strings.NewReader(document), buffer, SetRule("id=a", "new"))
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, strings.Replace(document, `<p id="a">`, "<p>", 1), buffer.String())
+	})
+
+	t.Run("no allocations", func(t *testing.T) {
+		if raceEnabled {
+			t.Skip("allocations are not counted reliably with the race detector")
+		}
+		count := 0
+		h := &Handler{
+			OnStartTag: func(t *Token) {
+				if _, ok := t.Attr("id"); ok {
+					t.Emit(t.Raw)
+				}
+			},
+			OnText: func(t *Token) {
+				count += len(t.Raw)
+			},
+		}
+		input := []byte(document)
+		r := bytes.NewReader(nil)
+		config := Config{Handler: h}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			r.Reset(input)
+			_ = config.Rewrite(r, io.Discard)
+		})
+		assert.Zero(t, allocs)
+	})
+}
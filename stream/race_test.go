@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package stream
+
+// raceEnabled reports whether the tests run with the race detector,
+// which makes allocation counts meaningless.
+const raceEnabled = true
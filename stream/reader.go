@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// reader pulls its source through a parse context
+// and hands out the output as it is produced.
+type reader struct {
+	src  io.Reader
+	pc   *parseContext
+	out  bytes.Buffer
+	done bool
+}
+
+// NewReader returns a reader yielding the html read from src
+// with the given rules applied to it. src is only read from
+// as the output is consumed. Closing the reader closes src
+// in case it is an io.Closer.
+func NewReader(src io.Reader, rules ...Rule) io.ReadCloser {
+	return Config{}.NewReader(src, rules...)
+}
+
+func (rd *reader) Read(p []byte) (int, error) {
+	if rd.pc == nil {
+		return 0, errors.New("read from a closed reader")
+	}
+
+	for rd.out.Len() == 0 && !rd.done {
+		rd.done = rd.pc.step()
+	}
+
+	if rd.out.Len() > 0 {
+		return rd.out.Read(p)
+	}
+
+	if err := rd.pc.result(); err != nil {
+		return 0, err
+	}
+	return 0, io.EOF
+}
+
+func (rd *reader) Close() error {
+	if rd.pc == nil {
+		return nil
+	}
+
+	defaultPool.Put(rd.pc)
+	rd.pc = nil
+
+	if c, ok := rd.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// countingReader counts the bytes read from it
+// and whether it was closed.
+type countingReader struct {
+	r      io.Reader
+	n      int
+	closed bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewReader(t *testing.T) {
+	appendedTag := "<h2>Example Sub Header</h2>"
+
+	t.Run("append", func(t *testing.T) {
+		r := NewReader(strings.NewReader(testAppendHtmlTemplate), AppendRule("id=headers", appendedTag))
+		output, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		equalStripped(t, testPostAppendHtmlTemplate, string(output))
+	})
+
+	t.Run("multiple rules", func(t *testing.T) {
+		r := NewReader(strings.NewReader(testSetHtmlTemplate),
+			SetRule("id=meow", "value"),
+			AppendRule("tag=head", "<title>Title</title>"),
+		)
+		output, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+
+		expected := strings.Replace(fmt.Sprintf(testSetHtmlTemplate, "value"), "</head>", "<title>Title</title></head>", 1)
+		assert.Equal(t, expected, string(output))
+	})
+
+	t.Run("matches push output", func(t *testing.T) {
+		entries, err := staticHtmlDir.ReadDir("static_html")
+		assert.Nil(t, err)
+
+		injectedValue := "<script>alert(1)</script>"
+		for _, entry := range entries {
+			t.Run(entry.Name(), func(t *testing.T) {
+				content, err := staticHtmlDir.ReadFile("static_html/" + entry.Name())
+				assert.Nil(t, err)
+
+				expected := &bytes.Buffer{}
+				assert.Nil(t, Append(bytes.NewReader(content), expected, "tag=head", injectedValue))
+
+				r := NewReader(iotest.HalfReader(bytes.NewReader(content)), AppendRule("tag=head", injectedValue))
+				output, err := ioutil.ReadAll(iotest.OneByteReader(r))
+				assert.Nil(t, err)
+				assert.Equal(t, expected.String(), string(output))
+			})
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		document := "<html><body>" + strings.Repeat("<p>paragraph</p>", 10*readBufferSize) + "</body></html>"
+		src := &countingReader{r: strings.NewReader(document)}
+
+		r := NewReader(src, AppendRule("tag=body", "<p>last</p>"))
+		_, err := r.Read(make([]byte, 16))
+		assert.Nil(t, err)
+		assert.True(t, src.n <= readBufferSize, src.n)
+
+		output, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, len(document)+len("<p>last</p>")-16, len(output))
+		assert.True(t, strings.HasSuffix(string(output), "<p>last</p></body></html>"))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := NewReader(strings.NewReader(testAppendHtmlTemplate), AppendRule("id=missing", appendedTag))
+		output, err := ioutil.ReadAll(r)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Equal(t, testAppendHtmlTemplate, string(output))
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		r := NewReader(strings.NewReader(testAppendHtmlTemplate), AppendRule("id=headers", "text"))
+		_, err := r.Read(make([]byte, 16))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		src := &countingReader{r: strings.NewReader(testAppendHtmlTemplate)}
+		r := NewReader(src, AppendRule("id=headers", appendedTag))
+		assert.Nil(t, r.Close())
+		assert.True(t, src.closed)
+
+		_, err := r.Read(make([]byte, 16))
+		assert.NotNil(t, err)
+		assert.Nil(t, r.Close())
+	})
+}
@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Action is the mutation a Rule applies
+// to the element it matched.
+type Action uint8
+
+const (
+	// SetAction replaces the content of the
+	// element with the value.
+	SetAction Action = iota
+	// AppendAction adds the value as the last
+	// child of the element.
+	AppendAction
+	// PrependAction adds the value as the first
+	// child of the element.
+	PrependAction
+	// RemoveAction removes the element along
+	// with its content.
+	RemoveAction
+	// AttrAction sets the attribute of the
+	// element to the value.
+	AttrAction
+)
+
+// needsContent reports whether the action applies to the
+// content of the element, which void elements don't have.
+func (a Action) needsContent() bool {
+	return a == SetAction || a == AppendAction || a == PrependAction
+}
+
+// Rule applies an Action to the first element matching its
+// Path, which matches elements matching any of its comma
+// separated matchers.
+type Rule struct {
+	Action Action
+	Path   string
+	Value  string
+	// Attribute is the name of the
+	// attribute set by AttrAction.
+	Attribute string
+	// Optional rules don't fail the
+	// rewrite when nothing matched them.
+	Optional bool
+}
+
+// SetRule creates a rule setting the content of the
+// element matching path to be the given value.
+func SetRule(path, value string) Rule {
+	return Rule{Action: SetAction, Path: path, Value: value}
+}
+
+// AppendRule creates a rule appending the given value
+// as the last child of the element matching path.
+func AppendRule(path, value string) Rule {
+	return Rule{Action: AppendAction, Path: path, Value: value}
+}
+
+// PrependRule creates a rule prepending the given value
+// as the first child of the element matching path.
+func PrependRule(path, value string) Rule {
+	return Rule{Action: PrependAction, Path: path, Value: value}
+}
+
+// RemoveRule creates a rule removing the
+// element matching path.
+func RemoveRule(path string) Rule {
+	return Rule{Action: RemoveAction, Path: path}
+}
+
+// AttrRule creates a rule setting the attribute of the element
+// matching path with the given name to be the given value.
+func AttrRule(path, name, value string) Rule {
+	return Rule{Action: AttrAction, Path: path, Attribute: name, Value: value}
+}
+
+// Validate checks that the rule can be applied, the same
+// checks are made by the engines before applying rules.
+func (r Rule) Validate() error {
+	return r.validate(CompileSelector)
+}
+
+// validate is Validate compiling the selectors
+// of css paths with the given function.
+func (r Rule) validate(compile func(string) (*Selector, error)) error {
+	if !validPath(r.Path) {
+		return fmt.Errorf("invalid path %q", r.Path)
+	}
+	if key, value := queryPath(r.Path).kv(); key == "css" {
+		if _, err := compile(value); err != nil {
+			return err
+		}
+	}
+
+	switch r.Action {
+	case SetAction, RemoveAction:
+	case AppendAction, PrependAction:
+		if len(r.Value) == 0 || r.Value[0] != '<' {
+			return errors.New("value must start with an html open tag '<'")
+		}
+	case AttrAction:
+		if r.Attribute == "" || strings.ContainsAny(r.Attribute, " \t\n\f\r\"'>/=") {
+			return fmt.Errorf("invalid attribute name %q", r.Attribute)
+		}
+	default:
+		return fmt.Errorf("unknown action %d", r.Action)
+	}
+
+	return nil
+}
+
+// validPath reports whether each of the comma separated
+// matchers of the path is a key=value pair, css paths
+// are checked by compiling their selector instead.
+func validPath(path string) bool {
+	if strings.HasPrefix(path, "css=") {
+		return true
+	}
+
+	for {
+		matcher := path
+		end := strings.IndexByte(path, ',')
+		if end >= 0 {
+			matcher = path[:end]
+		}
+		if strings.IndexByte(matcher, '=') <= 0 {
+			return false
+		}
+		if end < 0 {
+			return true
+		}
+		path = path[end+1:]
+	}
+}
+
+// ValidateRules validates each of the rules, the
+// first invalid one is reported along with its index.
+func ValidateRules(rules ...Rule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
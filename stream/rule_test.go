@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRule_Validate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		assert.Nil(t, SetRule("id=content", "text").Validate())
+		assert.Nil(t, AppendRule("tag=head", "<script></script>").Validate())
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		assert.NotNil(t, SetRule("content", "text").Validate())
+		assert.NotNil(t, SetRule("=content", "text").Validate())
+		assert.NotNil(t, SetRule("id=content,", "text").Validate())
+		assert.NotNil(t, SetRule("id=content,main", "text").Validate())
+		assert.Nil(t, SetRule("id=content,class=main", "text").Validate())
+	})
+
+	t.Run("append value without tag", func(t *testing.T) {
+		assert.NotNil(t, AppendRule("tag=head", "text").Validate())
+		assert.NotNil(t, AppendRule("tag=head", "").Validate())
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		assert.NotNil(t, Rule{Action: Action(200), Path: "id=content"}.Validate())
+	})
+}
+
+func TestValidateRules(t *testing.T) {
+	assert.Nil(t, ValidateRules(SetRule("id=content", "text"), RemoveRule("tag=b")))
+	assert.EqualError(t, ValidateRules(SetRule("id=content", "text"), SetRule("content", "text")),
+		`rule 1: invalid path "content"`)
+}
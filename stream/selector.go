@@ -0,0 +1,448 @@
+package stream
+
+import (
+	"fmt"
+	"github.com/html-overwrite/internal/markup"
+	"strings"
+)
+
+// combinator relates a compound selector to the previous one.
+type combinator uint8
+
+const (
+	descendantCombinator combinator = iota // "a b"
+	childCombinator                        // "a > b"
+)
+
+// attributeSelector matches an attribute by its name and,
+// unless op is zero, its value. op is the character
+// preceding '=' in the selector, '=' for exact matches.
+type attributeSelector struct {
+	name  string
+	op    byte
+	value string
+}
+
+// compoundSelector matches a single element, its
+// checks are the id, classes and attributes.
+type compoundSelector struct {
+	name    string // lowercased, empty for any element
+	id      string
+	hasID   bool
+	classes []string
+	attrs   []attributeSelector
+}
+
+// complexSelector is a chain of compound selectors, each
+// related to the previous one by its combinator.
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []combinator // the first one is unused
+}
+
+// Selector is a compiled selector list of the css subset the
+// stream engine evaluates against its open elements: type,
+// universal, id, class and attribute selectors along with the
+// descendant and child combinators.
+type Selector struct {
+	complex []complexSelector
+}
+
+// maxCompounds is the number of compound selectors a complex
+// selector may chain, as well as the number of checks each
+// of them may make.
+const maxCompounds = 64
+
+// selectorState tracks the progress of a complex selector along
+// the open elements. bit k of self is set when compounds 0 to k
+// match up to the element, ancestors is the union of self for the
+// element and all of its ancestors.
+type selectorState struct {
+	self      uint64
+	ancestors uint64
+}
+
+// lookaheadPseudoClasses depend on the elements following the one
+// they are matched against, which a stream can't tell in time.
+var lookaheadPseudoClasses = map[string]bool{
+	"last-child": true, "last-of-type": true, "only-child": true,
+	"only-of-type": true, "nth-last-child": true, "nth-last-of-type": true,
+	"has": true, "empty": true,
+}
+
+// CompileSelector compiles the given selector list. Selectors the
+// stream engine can't evaluate, like those needing to look ahead of
+// the element as :last-child and :has do, are rejected.
+func CompileSelector(s string) (*Selector, error) {
+	p := selectorParser{src: s}
+	sel, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("selector %q: %w", s, err)
+	}
+	return sel, nil
+}
+
+// MustCompileSelector is like CompileSelector
+// but panics if the selector can't be compiled.
+func MustCompileSelector(s string) *Selector {
+	sel, err := CompileSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// selectorParser parses selectors from src
+// starting at pos.
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) parse() (*Selector, error) {
+	sel := &Selector{}
+	for {
+		p.skipSpace()
+		c, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		sel.complex = append(sel.complex, c)
+
+		if p.pos == len(p.src) {
+			return sel, nil
+		}
+		// parseComplex stops at commas only
+		p.pos++
+	}
+}
+
+func (p *selectorParser) parseComplex() (complexSelector, error) {
+	var c complexSelector
+	comb := descendantCombinator
+	for {
+		compound, err := p.parseCompound()
+		if err != nil {
+			return c, err
+		}
+		if len(c.compounds) == maxCompounds {
+			return c, fmt.Errorf("more than %d compound selectors", maxCompounds)
+		}
+		c.compounds = append(c.compounds, compound)
+		c.combinators = append(c.combinators, comb)
+
+		spaced := p.skipSpace()
+		if p.pos == len(p.src) || p.src[p.pos] == ',' {
+			return c, nil
+		}
+
+		switch p.src[p.pos] {
+		case '>':
+			p.pos++
+			p.skipSpace()
+			comb = childCombinator
+		case '+', '~':
+			return c, fmt.Errorf("the %q combinator is not supported", p.src[p.pos])
+		default:
+			if !spaced {
+				return c, p.unexpected()
+			}
+			comb = descendantCombinator
+		}
+	}
+}
+
+func (p *selectorParser) parseCompound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+
+	if p.pos < len(p.src) && p.src[p.pos] == '*' {
+		p.pos++
+	} else if name := p.ident(); name != "" {
+		c.name = strings.ToLower(name)
+	}
+
+	checks := 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '#':
+			p.pos++
+			id := p.ident()
+			if id == "" {
+				return c, p.unexpected()
+			}
+			if c.hasID && c.id != id {
+				return c, fmt.Errorf("conflicting ids %q and %q", c.id, id)
+			}
+			c.id, c.hasID = id, true
+		case '.':
+			p.pos++
+			class := p.ident()
+			if class == "" {
+				return c, p.unexpected()
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			p.pos++
+			attr, err := p.parseAttribute()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+		case ':':
+			p.pos++
+			if p.pos < len(p.src) && p.src[p.pos] == ':' {
+				return c, fmt.Errorf("pseudo-elements are not supported")
+			}
+			name := strings.ToLower(p.ident())
+			if lookaheadPseudoClasses[name] {
+				return c, fmt.Errorf(":%s needs to look ahead of the element, which a stream can't", name)
+			}
+			return c, fmt.Errorf("the :%s pseudo-class is not supported", name)
+		default:
+			if p.pos == start {
+				return c, p.unexpected()
+			}
+			return c, nil
+		}
+
+		if checks++; checks > maxCompounds {
+			return c, fmt.Errorf("more than %d checks in a compound selector", maxCompounds)
+		}
+	}
+
+	if p.pos == start {
+		return c, p.unexpected()
+	}
+	return c, nil
+}
+
+// parseAttribute parses an attribute selector following its '['.
+func (p *selectorParser) parseAttribute() (attributeSelector, error) {
+	var attr attributeSelector
+
+	p.skipSpace()
+	if attr.name = strings.ToLower(p.ident()); attr.name == "" {
+		return attr, p.unexpected()
+	}
+	p.skipSpace()
+
+	if p.pos < len(p.src) && p.src[p.pos] == ']' {
+		p.pos++
+		return attr, nil
+	}
+
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "="):
+		attr.op = '='
+		p.pos++
+	case len(p.src)-p.pos >= 2 && strings.IndexByte("~|^$*", p.src[p.pos]) >= 0 && p.src[p.pos+1] == '=':
+		attr.op = p.src[p.pos]
+		p.pos += 2
+	default:
+		return attr, p.unexpected()
+	}
+	p.skipSpace()
+
+	if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+		end := strings.IndexByte(p.src[p.pos+1:], p.src[p.pos])
+		if end < 0 {
+			return attr, fmt.Errorf("unterminated string at offset %d", p.pos)
+		}
+		attr.value = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else if attr.value = p.ident(); attr.value == "" {
+		return attr, p.unexpected()
+	}
+
+	p.skipSpace()
+	if p.pos == len(p.src) || p.src[p.pos] != ']' {
+		return attr, p.unexpected()
+	}
+	p.pos++
+	return attr, nil
+}
+
+// ident parses an identifier, escapes aren't supported.
+func (p *selectorParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if !isLetter(c) && !('0' <= c && c <= '9') && c != '-' && c != '_' && c < 0x80 {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// skipSpace skips whitespace and reports whether there was any.
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.src) && markup.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *selectorParser) unexpected() error {
+	if p.pos == len(p.src) {
+		return fmt.Errorf("unexpected end")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+}
+
+// advance returns the state of the selector for the given start tag
+// from the state of its parent, which is zero for the root element.
+func (c *complexSelector) advance(tag, name []byte, parent selectorState, fold bool) selectorState {
+	var s selectorState
+	for k := range c.compounds {
+		if k > 0 {
+			prev := uint64(1) << (k - 1)
+			if c.combinators[k] == childCombinator && parent.self&prev == 0 {
+				continue
+			}
+			if c.combinators[k] == descendantCombinator && parent.ancestors&prev == 0 {
+				continue
+			}
+		}
+
+		if c.compounds[k].match(tag, name, fold) {
+			s.self |= 1 << k
+		}
+	}
+	s.ancestors = parent.ancestors | s.self
+	return s
+}
+
+// matched reports whether the whole selector matches
+// the element the state belongs to.
+func (c *complexSelector) matched(s selectorState) bool {
+	return s.self&(1<<(len(c.compounds)-1)) != 0
+}
+
+// match reports whether the compound matches the given start tag.
+func (c *compoundSelector) match(tag, name []byte, fold bool) bool {
+	if c.name != "" && !equalFold(name, c.name) {
+		return false
+	}
+
+	checks := len(c.classes) + len(c.attrs)
+	if c.hasID {
+		checks++
+	}
+	if checks == 0 {
+		return true
+	}
+
+	// each check owns a bit, the id comes first
+	// followed by the classes and the attributes
+	var passed uint64
+	attrs := markup.ScanAttributes(tag)
+	for attrs.Next() {
+		key, value := attrs.Attr.Key(tag), attrs.Attr.Value(tag)
+		bit := 0
+		if c.hasID {
+			if equalFold(key, "id") && equalValue(value, c.id, fold) {
+				passed |= 1
+			}
+			bit++
+		}
+		for _, class := range c.classes {
+			if equalFold(key, "class") && hasClass(value, class, fold) {
+				passed |= 1 << bit
+			}
+			bit++
+		}
+		for _, attr := range c.attrs {
+			if equalFold(key, attr.name) && attr.match(value, fold) {
+				passed |= 1 << bit
+			}
+			bit++
+		}
+	}
+
+	return passed == 1<<checks-1
+}
+
+// match reports whether the attribute value matches.
+func (a *attributeSelector) match(value []byte, fold bool) bool {
+	switch a.op {
+	case 0:
+		return true
+	case '=':
+		return equalValue(value, a.value, fold)
+	case '~':
+		return hasClass(value, a.value, fold)
+	case '|':
+		return equalValue(value, a.value, fold) ||
+			(len(value) > len(a.value) && value[len(a.value)] == '-' && equalValue(value[:len(a.value)], a.value, fold))
+	case '^':
+		return a.value != "" && len(value) >= len(a.value) && equalValue(value[:len(a.value)], a.value, fold)
+	case '$':
+		return a.value != "" && len(value) >= len(a.value) && equalValue(value[len(value)-len(a.value):], a.value, fold)
+	case '*':
+		for i := 0; a.value != "" && i+len(a.value) <= len(value); i++ {
+			if equalValue(value[i:i+len(a.value)], a.value, fold) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// compiledSelector is the selector of the css path
+// of a rule, or the error compiling it failed with.
+type compiledSelector struct {
+	src string
+	sel *Selector
+	err error
+}
+
+// compileSelector compiles the selector of the css path of the
+// rule at index i. The selectors of the rules the context was last
+// set up with are kept, so the same rules are compiled once for
+// all the inputs the pooled context parses.
+func (pc *parseContext) compileSelector(i int, s string) (*Selector, error) {
+	for len(pc.compiled) <= i {
+		pc.compiled = append(pc.compiled, compiledSelector{})
+	}
+
+	c := &pc.compiled[i]
+	if c.src != s || (c.sel == nil && c.err == nil) {
+		sel, err := CompileSelector(s)
+		*c = compiledSelector{src: s, sel: sel, err: err}
+	}
+	return c.sel, c.err
+}
+
+// ruleSelector is a complex selector of the css path of a rule.
+type ruleSelector struct {
+	rule int
+	sel  *complexSelector
+}
+
+// advanceSelectors computes the state of the selectors of the css
+// rules at the given start tag from the state of its parent, it's
+// kept for every start tag, matched or not, so the state of the open
+// elements stays in line with them.
+func (pc *parseContext) advanceSelectors(tag, name []byte) {
+	n := len(pc.selectors)
+	if n == 0 {
+		return
+	}
+
+	var parent []selectorState
+	if depth := len(pc.stack); depth > 0 {
+		parent = pc.states[(depth-1)*n : depth*n]
+	}
+	for j, s := range pc.selectors {
+		var state selectorState
+		if parent != nil {
+			state = parent[j]
+		}
+		pc.current[j] = s.sel.advance(tag, name, state, pc.config.FoldValues)
+	}
+}
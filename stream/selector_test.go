@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSelector(t *testing.T) {
+	const document = `<html><body>
+<aside><span class="price">1</span></aside>
+<main id="shop"><div><span class="price sale" data-currency="usd">2</span></div><span class="price">3</span></main>
+</body></html>`
+
+	rewrite := func(t *testing.T, config Config, r io.Reader, rules ...Rule) string {
+		t.Helper()
+		out := &bytes.Buffer{}
+		assert.Nil(t, config.Rewrite(r, out, rules...))
+		return out.String()
+	}
+
+	t.Run("compile errors", func(t *testing.T) {
+		for selector, msg := range map[string]string{
+			"li:last-child":    `selector "li:last-child": :last-child needs to look ahead of the element, which a stream can't`,
+			"div:has(p)":       `selector "div:has(p)": :has needs to look ahead of the element, which a stream can't`,
+			"a:hover":          `selector "a:hover": the :hover pseudo-class is not supported`,
+			"p:first-child":    `selector "p:first-child": the :first-child pseudo-class is not supported`,
+			"p::before":        `selector "p::before": pseudo-elements are not supported`,
+			"h1 + p":           `selector "h1 + p": the '+' combinator is not supported`,
+			"h1 ~ p":           `selector "h1 ~ p": the '~' combinator is not supported`,
+			"":                 `selector "": unexpected end`,
+			"div >":            `selector "div >": unexpected end`,
+			"a,,b":             `selector "a,,b": unexpected ',' at offset 2`,
+			"[href":            `selector "[href": unexpected end`,
+			`[href="x]`:        `selector "[href=\"x]": unterminated string at offset 6`,
+			"#a#b":             `selector "#a#b": conflicting ids "a" and "b"`,
+			"div!":             `selector "div!": unexpected '!' at offset 3`,
+			"a[href=x y]":      `selector "a[href=x y]": unexpected 'y' at offset 9`,
+			"section >> a":     `selector "section >> a": unexpected '>' at offset 9`,
+			"ul li:only-child": `selector "ul li:only-child": :only-child needs to look ahead of the element, which a stream can't`,
+		} {
+			_, err := CompileSelector(selector)
+			if assert.Error(t, err, selector) {
+				assert.Equal(t, msg, err.Error())
+			}
+		}
+
+		assert.Panics(t, func() { MustCompileSelector("li:last-child") })
+		assert.Equal(t, `selector "li:last-child": :last-child needs to look ahead of the element, which a stream can't`,
+			Rewrite(strings.NewReader(document), io.Discard, SetRule("css=li:last-child", "x")).Error())
+	})
+
+	t.Run("malformed paths", func(t *testing.T) {
+		for _, path := range []string{"content", "css", "id=a,main", "css=main >"} {
+			var err error
+			assert.NotPanics(t, func() {
+				err = Config{}.Rewrite(strings.NewReader(document), io.Discard, SetRule(path, "x"))
+			}, path)
+			assert.NotNil(t, err, path)
+		}
+	})
+
+	t.Run("compile", func(t *testing.T) {
+		sel := MustCompileSelector(` body > main .price , A[Href^="https:"][rel~=nofollow]`)
+		assert.Equal(t, []complexSelector{{
+			compounds: []compoundSelector{
+				{name: "body"},
+				{name: "main"},
+				{classes: []string{"price"}},
+			},
+			combinators: []combinator{descendantCombinator, childCombinator, descendantCombinator},
+		}, {
+			compounds: []compoundSelector{{name: "a", attrs: []attributeSelector{
+				{name: "href", op: '^', value: "https:"},
+				{name: "rel", op: '~', value: "nofollow"},
+			}}},
+			combinators: []combinator{descendantCombinator},
+		}}, sel.complex)
+	})
+
+	t.Run("descendant and child", func(t *testing.T) {
+		out := rewrite(t, Config{}, strings.NewReader(document), SetRule("css=body > main .price", "x"))
+		assert.Contains(t, out, `<aside><span class="price">1</span></aside>`)
+		assert.Contains(t, out, `<span class="price sale" data-currency="usd">x</span>`)
+
+		out = rewrite(t, Config{}, strings.NewReader(document), SetRule("css=main > .price", "x"))
+		assert.Contains(t, out, `<span class="price sale" data-currency="usd">2</span></div><span class="price">x</span>`)
+
+		matches, err := Config{}.RewriteMatches(strings.NewReader(document), io.Discard, SetRule("css=html > main .price", "x"))
+		assert.Error(t, err)
+		assert.False(t, matches[0].Matched)
+	})
+
+	t.Run("compounds", func(t *testing.T) {
+		for selector, want := range map[string]string{
+			"#shop span.price.sale":       "2",
+			"main#shop > span":            "3",
+			"*.price":                     "1",
+			"[data-currency]":             "2",
+			"span[data-currency=usd]":     "2",
+			`[class~="sale"]`:             "2",
+			"[class^=price][class$=sale]": "2",
+			"[class*='ce s']":             "2",
+			"[data-currency|=us]":         "",
+			"aside .price, main > .price": "1",
+			"SPAN.price":                  "1",
+		} {
+			rule := SetRule("css="+selector, "x")
+			rule.Optional = true
+			out := &bytes.Buffer{}
+			assert.Nil(t, Rewrite(strings.NewReader(document), out, rule), selector)
+
+			var got string
+			for _, n := range []string{"1", "2", "3"} {
+				if !strings.Contains(out.String(), ">"+n+"<") {
+					got += n
+				}
+			}
+			assert.Equal(t, want, got, selector)
+		}
+	})
+
+	t.Run("attribute operators", func(t *testing.T) {
+		for _, test := range []struct {
+			attr attributeSelector
+			in   string
+			want bool
+		}{
+			{attributeSelector{op: '='}, "", true},
+			{attributeSelector{op: '=', value: "a"}, "A", false},
+			{attributeSelector{op: '|', value: "en"}, "en", true},
+			{attributeSelector{op: '|', value: "en"}, "en-US", true},
+			{attributeSelector{op: '|', value: "en"}, "english", false},
+			{attributeSelector{op: '^', value: ""}, "a", false},
+			{attributeSelector{op: '^', value: "ab"}, "abc", true},
+			{attributeSelector{op: '$', value: "bc"}, "abc", true},
+			{attributeSelector{op: '$', value: "abcd"}, "abc", false},
+			{attributeSelector{op: '*', value: "b"}, "abc", true},
+			{attributeSelector{op: '*', value: "d"}, "abc", false},
+			{attributeSelector{op: '~', value: "b"}, "a  b", true},
+			{attributeSelector{op: '~', value: "a b"}, "a b", false},
+		} {
+			assert.Equal(t, test.want, test.attr.match([]byte(test.in), false), "%c %q %q", test.attr.op, test.attr.value, test.in)
+		}
+
+		assert.True(t, (&attributeSelector{op: '^', value: "AB"}).match([]byte("abc"), true))
+	})
+
+	t.Run("fold values", func(t *testing.T) {
+		out := rewrite(t, Config{FoldValues: true}, strings.NewReader(document), SetRule("css=#SHOP [DATA-CURRENCY=USD]", "x"))
+		assert.Contains(t, out, `data-currency="usd">x</span>`)
+		assert.Error(t, Rewrite(strings.NewReader(document), io.Discard, SetRule("css=#SHOP span", "x")))
+	})
+
+	t.Run("implied ends and void elements", func(t *testing.T) {
+		const list = `<html><body><ul><li>a<li><img class="icon"><span>b</span></ul></body></html>`
+		out := rewrite(t, Config{}, strings.NewReader(list), SetRule("css=ul > li > span", "x"))
+		assert.Equal(t, `<html><body><ul><li>a<li><img class="icon"><span>x</span></ul></body></html>`, out)
+
+		assert.Error(t, Rewrite(strings.NewReader(list), io.Discard, SetRule("css=img span", "x")))
+		assert.Error(t, Rewrite(strings.NewReader(list), io.Discard, SetRule("css=li > li", "x")))
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		out := rewrite(t, Config{Fragment: true}, strings.NewReader(`<div class="card"><p>a</p></div>`), SetRule("css=.card > p", "x"))
+		assert.Equal(t, `<div class="card"><p>x</p></div>`, out)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		want := rewrite(t, Config{}, strings.NewReader(document), SetRule("css=body > main .price", "x"))
+		got := rewrite(t, Config{}, iotest.OneByteReader(strings.NewReader(document)), SetRule("css=body > main .price", "x"))
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("reused context", func(t *testing.T) {
+		pc := newParseCtx(nil, nil)
+		for _, path := range []string{"css=main span", "css=main :has(b)", "css=aside span", "id=a"} {
+			pc.reset(nil, nil)
+			pc.setup(Config{}, []Rule{SetRule("css=#shop", "x"), SetRule(path, "x")})
+			// only the selectors of the last rules are kept
+			assert.Len(t, pc.compiled, 2)
+		}
+		assert.Equal(t, "aside span", pc.compiled[1].src)
+
+		pc.reset(nil, nil)
+		pc.setup(Config{}, []Rule{SetRule("css=main :has(b)", "x")})
+		assert.EqualError(t, pc.err, `selector "main :has(b)": :has needs to look ahead of the element, which a stream can't`)
+	})
+
+	t.Run("zero allocs", func(t *testing.T) {
+		if raceEnabled {
+			t.Skip("allocations are not counted reliably with the race detector")
+		}
+		input := []byte(document)
+		r := bytes.NewReader(nil)
+		rules := []Rule{SetRule("css=body > main .price", "x"), AttrRule("css=aside span, #shop", "title", "y")}
+		assert.Nil(t, Rewrite(bytes.NewReader(input), io.Discard, rules...))
+
+		allocs := testing.AllocsPerRun(100, func() {
+			r.Reset(input)
+			_ = Rewrite(r, io.Discard, rules...)
+		})
+		assert.Zero(t, allocs)
+	})
+}
@@ -4,451 +4,923 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"github.com/html-overwrite/internal/markup"
+	"github.com/html-overwrite/model"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/text/encoding"
+	"html"
 	"io"
+	"strings"
 )
 
+// readBufferSize is the size of the blocks read from
+// the input and handed over to the output.
+const readBufferSize = 4096
+
+// maxConsecutiveEmptyReads is the amount of reads returning
+// neither data nor an error tolerated before giving up on r.
+const maxConsecutiveEmptyReads = 100
+
+// ErrNotFound is returned when no element matched
+// the path of a rule.
+var ErrNotFound = model.ErrNotFound
+
 type parseContext struct {
-	r             io.Reader // reader to read from
-	w             io.Writer // writer to write to
-	runeBuffer    [3]byte   // buffer that holds current seeked runes
-	generalBuffer []byte    // buffer to hold attributes to match to
-	end           bool
-	skipWrite     bool
-	i             int
+	r         io.Reader // reader to read from
+	w         io.Writer // writer to write to
+	buffer    []byte    // block of input currently being parsed
+	pos       int       // position of the next token within buffer
+	written   int       // position up to which buffer was handed over to w
+	eof       bool      // r has no more data to give
+	skipWrite bool      // consumed input is dropped instead of written
+	err       error     // error that stopped the parsing
+	readErr   error     // error returned by r after its data
+
+	rules     []Rule             // rules applied to the input
+	matches   []match            // progress of each of the rules
+	stack     []element          // currently open elements
+	names     []byte             // lowercased names of the open elements of no atom
+	skipDepth int                // depth of the element whose content is dropped
+	rawText   bool               // inside the content of a text element
+	config    Config             // options the input is parsed with
+	detected  bool               // the encoding of the input was determined
+	position  model.Position     // position of the input at advanced
+	advanced  int                // offset in the buffer position was advanced to
+	event     Token              // token handed over to the handler
+	opened    []int              // rules matching the current start tag
+	compiled  []compiledSelector // selectors of the css rules, kept across inputs
+	selectors []ruleSelector     // complex selectors of the css rules
+	states    []selectorState    // state of each selector per open element
+	current   []selectorState    // state of each selector at the current start tag
+	tagBuf    [2][]byte          // scratch space for rewritten tags
 }
 
-func (pc *parseContext) resetGeneralBuffer() {
-	pc.generalBuffer = pc.generalBuffer[:0]
+// match tracks the element matched by a rule.
+type match struct {
+	matched  bool
+	open     bool           // the matched element wasn't closed yet
+	depth    int            // depth the matched element was opened at
+	position model.Position // position of the start tag of the element
 }
 
-func (pc *parseContext) attribute() []byte {
-	return pc.generalBuffer
+// setup prepares the context to parse its
+// input with the given config and rules.
+func (pc *parseContext) setup(config Config, rules []Rule) {
+	pc.config = config
+	pc.rules = append(pc.rules[:0], rules...)
+	pc.matches = pc.matches[:0]
+	pc.selectors = pc.selectors[:0]
+	for i, rule := range rules {
+		compile := func(s string) (*Selector, error) {
+			return pc.compileSelector(i, s)
+		}
+		if err := rule.validate(compile); err != nil && pc.err == nil {
+			pc.err = err
+		}
+		pc.matches = append(pc.matches, match{})
+
+		// checked by prefix, the path may be malformed
+		if strings.HasPrefix(rule.Path, "css=") {
+			if sel, err := compile(rule.Path[len("css="):]); err == nil {
+				for j := range sel.complex {
+					pc.selectors = append(pc.selectors, ruleSelector{rule: i, sel: &sel.complex[j]})
+				}
+			}
+		}
+	}
+	pc.current = pc.current[:0]
+	pc.current = append(pc.current, make([]selectorState, len(pc.selectors))...)
+}
+
+// compact drops the input that was already handled and
+// moves the rest of it to the start of the buffer.
+func (pc *parseContext) compact() {
+	pc.flush(pc.pos)
+	// the position is advanced past the input that is dropped
+	pc.at()
+	pc.advanced = 0
+
+	n := copy(pc.buffer[:cap(pc.buffer)], pc.buffer[pc.pos:])
+	pc.buffer = pc.buffer[:n]
+	pc.written -= pc.pos
+	pc.pos = 0
+
+	if len(pc.buffer) == cap(pc.buffer) {
+		// a single token outgrew the buffer
+		grown := make([]byte, len(pc.buffer), 2*cap(pc.buffer))
+		copy(grown, pc.buffer)
+		pc.buffer = grown
+	}
 }
 
-func (pc *parseContext) initialRead() {
-	now := pc.now()
+// fill reads the next block of r right after the input that
+// wasn't handled yet. read errors don't interrupt parsing, the
+// data that was read is still handled and the error is kept
+// for the caller.
+func (pc *parseContext) fill() {
+	pc.compact()
+
+	for empty := 0; !pc.eof; empty++ {
+		n, err := pc.r.Read(pc.buffer[len(pc.buffer):cap(pc.buffer)])
+		if n < 0 || n > cap(pc.buffer)-len(pc.buffer) {
+			panic(errors.New("reader returned an invalid count"))
+		}
+		pc.buffer = pc.buffer[:len(pc.buffer)+n]
+
+		if err != nil {
+			pc.eof = true
+			if err != io.EOF {
+				pc.readErr = err
+			}
+		}
 
-	_, err := pc.r.Read(pc.runeBuffer[1:])
-	if err != nil {
-		panic(err)
+		if n > 0 {
+			break
+		}
+
+		if empty == maxConsecutiveEmptyReads {
+			pc.eof = true
+			pc.readErr = io.ErrNoProgress
+		}
 	}
+}
+
+// feed hands b over as the next block of input, it
+// is used in place of fill when the input is pushed.
+func (pc *parseContext) feed(b []byte) {
+	for len(b) > 0 && pc.err == nil {
+		pc.compact()
 
-	// assign the newly read values from the read buffer
-	// to the runes buffer
-	pc.runeBuffer[0] = now
+		n := copy(pc.buffer[len(pc.buffer):cap(pc.buffer)], b)
+		pc.buffer = pc.buffer[:len(pc.buffer)+n]
+		b = b[n:]
+
+		pc.process()
+		pc.flush(pc.pos)
+	}
 }
 
-func (pc *parseContext) read() {
-	// read & shift buffer positions
-	now := pc.now()
-	following := pc.following()
+// flush hands the buffer over to w up to the given position
+// unless writing is currently skipped.
+func (pc *parseContext) flush(upto int) {
+	if upto <= pc.written {
+		return
+	}
 
-	_, err := pc.r.Read(pc.runeBuffer[2:])
-	if err != nil {
-		if err.Error() == "EOF" {
-			pc.end = true
-			return
-		}
-		panic(err)
+	if !pc.skipWrite {
+		pc.output(pc.buffer[pc.written:upto])
 	}
 
-	// assign the newly read values from the read buffer
-	// to the runes buffer
-	pc.runeBuffer[0], pc.runeBuffer[1] = now, following
+	pc.written = upto
 }
 
-func (pc *parseContext) writeOutput() []byte {
+// discard drops the buffer up to the given position
+// without handing it over to w.
+func (pc *parseContext) discard(upto int) {
+	if upto > pc.written {
+		pc.written = upto
+	}
+}
 
-	if pc.end {
-		return pc.runeBuffer[2:]
+// output writes b to w, a failing writer
+// stops the parsing.
+func (pc *parseContext) output(b []byte) {
+	if pc.err != nil {
+		return
 	}
 
-	return pc.runeBuffer[1:2]
+	if _, err := pc.w.Write(b); err != nil {
+		pc.err = fmt.Errorf("failed to write output at %v: %w", pc.at(), err)
+	}
 }
 
-func (pc *parseContext) next() {
+// step reads the next block of input and handles every token
+// it completes, it reports whether the parsing is over.
+func (pc *parseContext) step() bool {
+	if pc.err == nil {
+		pc.fill()
+		pc.process()
+		pc.flush(pc.pos)
+	}
 
-	if pc.end {
-		panic(errors.New("cannot continue when reader is at EOF"))
+	if pc.err != nil {
+		return true
 	}
 
-	if pc.i == 0 {
-		// on first iteration we need to read twice
-		pc.initialRead()
-	} else {
-		pc.read()
+	if pc.eof {
+		pc.finish()
+		return true
 	}
 
-	if !pc.skipWrite {
-		// we need to write
-		if _, err := pc.w.Write(pc.writeOutput()); err != nil {
-			panic(fmt.Errorf("failed to write output: %v", err))
+	return false
+}
+
+// process handles every complete token buffered after pos.
+func (pc *parseContext) process() {
+	if !pc.detected {
+		// the encoding is told by the start of the input
+		e, name, ok := markup.EncodingOf(pc.buffer, pc.config.ContentType, pc.eof)
+		if !ok {
+			return
 		}
+		pc.useEncoding(e, name)
 	}
 
-	pc.i++
+	for pc.err == nil && pc.pos < len(pc.buffer) {
+		n := pc.token(pc.buffer[pc.pos:])
+		if n == 0 {
+			// more input is needed
+			return
+		}
+		pc.pos += n
+	}
 }
 
-func (pc *parseContext) now() uint8 {
-	return pc.runeBuffer[1]
+// useEncoding puts the values of the rules in the encoding of the
+// input, as they are written among the bytes of the input as they
+// are. Attribute values are escaped beforehand so the characters the
+// encoding lacks can be written as character references.
+func (pc *parseContext) useEncoding(e encoding.Encoding, name string) {
+	pc.detected = true
+
+	for i := range pc.rules {
+		if pc.rules[i].Action == AttrAction {
+			pc.rules[i].Value = html.EscapeString(pc.rules[i].Value)
+		}
+	}
+
+	if e == nil {
+		return
+	}
+	if name == "utf-16be" || name == "utf-16le" {
+		pc.err = fmt.Errorf("%s documents are not supported", name)
+		return
+	}
+
+	encoder := encoding.HTMLEscapeUnsupported(e.NewEncoder())
+	for i := range pc.rules {
+		value, err := encoder.String(pc.rules[i].Value)
+		if err != nil {
+			pc.err = fmt.Errorf("failed to encode the value of rule %d to %s: %w", i, name, err)
+			return
+		}
+		pc.rules[i].Value = value
+	}
+}
+
+// at returns the position of the token at pos, which is
+// tracked lazily as it is only needed once in a while.
+func (pc *parseContext) at() model.Position {
+	pc.position = pc.position.Advance(pc.buffer[pc.advanced:pc.pos])
+	pc.advanced = pc.pos
+	return pc.position
 }
 
-func (pc *parseContext) following() uint8 {
-	return pc.runeBuffer[2]
+// finish hands over whatever is left of the input
+// and checks every rule was applied.
+func (pc *parseContext) finish() {
+	if pc.err == nil {
+		// elements whose end tag is optional are ended by the
+		// end of the input, up to the first one that isn't
+		depth := len(pc.stack)
+		for depth > 0 && pc.stack[depth-1].kind&optionalEnd != 0 {
+			depth--
+		}
+		pc.closeElements(depth, len(pc.buffer), len(pc.buffer))
+	}
+
+	pc.flush(len(pc.buffer))
+	pc.pos = len(pc.buffer)
+
+	if pc.err != nil {
+		return
+	}
+
+	for i, m := range pc.matches {
+		path := pc.rules[i].Path
+		if !m.matched && !pc.rules[i].Optional {
+			pc.err = fmt.Errorf("element matching %q was not found: %w", path, ErrNotFound)
+			return
+		}
+		if m.open {
+			pc.err = fmt.Errorf("element matching %q opened at %v was not closed: %w", path, m.position, io.ErrUnexpectedEOF)
+			return
+		}
+	}
 }
 
-func (pc *parseContext) before() uint8 {
-	return pc.runeBuffer[0]
+// result returns the error the parsing ended with, errors of
+// the underlying reader take precedence as they are the root cause.
+func (pc *parseContext) result() error {
+	if pc.readErr != nil {
+		return fmt.Errorf("failed to read input at %v: %w", pc.at(), pc.readErr)
+	}
+	return pc.err
 }
 
 func (pc *parseContext) reset(r io.Reader, w io.Writer) {
 	pc.r = r
 	pc.w = w
-	pc.generalBuffer = pc.generalBuffer[:0]
+	pc.buffer = pc.buffer[:0]
+	pc.pos = 0
+	pc.written = 0
+	pc.eof = false
 	pc.skipWrite = false
-	pc.end = false
-	pc.i = 0
+	pc.err = nil
+	pc.readErr = nil
+	for i := range pc.rules {
+		pc.rules[i] = Rule{}
+	}
+	pc.rules = pc.rules[:0]
+	pc.matches = pc.matches[:0]
+	pc.stack = pc.stack[:0]
+	pc.names = pc.names[:0]
+	pc.selectors = pc.selectors[:0]
+	pc.states = pc.states[:0]
+	pc.skipDepth = -1
+	pc.rawText = false
+	pc.config = Config{}
+	pc.detected = false
+	pc.position = model.StartPosition
+	pc.advanced = 0
+	pc.event = Token{}
 }
 
-type queryPath string
+var commentOpener = []byte("<!--")
+var cdataOpener = []byte("<![CDATA[")
+var cdataCloser = []byte("]]>")
+var commentDashes = []byte("--")
 
-func (q queryPath) kv() (string, string) {
-	return splitStringOnEqual(string(q))
-}
-
-var nonClosingHeaderTags = [][]byte{[]byte("meta"), []byte("link")}
+// token handles the token at the start of b and returns its
+// length, zero is returned when b doesn't hold all of it yet.
+func (pc *parseContext) token(b []byte) int {
+	if pc.rawText {
+		return pc.rawTextToken(b)
+	}
 
-func isNonClosingTag(pc *parseContext) bool {
-	for _, tag := range nonClosingHeaderTags {
-		if bytes.Equal(pc.generalBuffer, tag) {
-			return true
-		}
+	if b[0] != '<' {
+		return pc.text(b, textLength(b))
 	}
-	return false
-}
 
-// given a ctx where 'now' is the comment tag opener
-// it will continue until the end of the comment tag
-func untilCommentEnd(pc *parseContext) {
-	for ; !pc.end; pc.next() {
-		if pc.now() == '>' && pc.before() == '-' {
-			break
+	// "<![" may only open a CDATA section in foreign content
+	cdata := len(b) > 2 && b[1] == '!' && b[2] == '[' && pc.inForeignContent(0)
+	n := tokenLength(b, cdata)
+	if n == 0 {
+		if !pc.eof {
+			return 0
 		}
+		// unterminated markup at the end of the input
+		// is passed on as is
+		return len(b)
 	}
-}
-
-func untilCurrentTagCloseTagStart(pc *parseContext) {
-	depth := 0
-	for ; !pc.end; pc.next() {
-		untilNextOpen(pc)
 
-		// skip over comment tags
-		if pc.following() == '!' {
-			untilCommentEnd(pc)
-			continue
-		}
+	// the handler sees end tags once their elements are
+	// closed, so the rules append ahead of the end tags
+	switch {
+	case b[1] == '/':
+		pc.endTag(endTagName(b[:n]), pc.pos, pc.pos+n)
+		pc.dispatch(EndTagToken, b[:n])
+	case isLetter(b[1]):
+		replaced := pc.dispatch(StartTagToken, b[:n])
+		pc.startTag(b[:n], pc.pos, pc.pos+n, !replaced)
+	case bytes.HasPrefix(b, commentOpener):
+		pc.dispatch(CommentToken, b[:n])
+	}
 
-		// make sure were in an opening tag
-		if pc.following() != '/' {
-			pc.resetGeneralBuffer()
-			for ; !pc.end; pc.next() {
-				if pc.now() == ' ' || pc.now() == '>' {
-					break
-				}
-				if pc.now() == '<' {
-					continue
-				}
-				pc.generalBuffer = append(pc.generalBuffer, pc.now())
-			}
+	return n
+}
 
-			if isNonClosingTag(pc) {
-				// skip over non-closing tags
-				continue
-			}
+// rawTextToken handles the content of text elements, which
+// is text up to the end tag of the element.
+func (pc *parseContext) rawTextToken(b []byte) int {
+	if b[0] != '<' {
+		return pc.text(b, textLength(b))
+	}
 
-			if shouldTagContentBeSkipped(pc) {
-				// skip over content first
-				untilNextOpen(pc)
-				untilNextEnd(pc)
-				continue
-			}
+	if len(b) < 2 {
+		if !pc.eof {
+			return 0
 		}
+		return pc.text(b, len(b))
+	}
 
-		if pc.following() == '/' {
-			if depth == 0 {
-				// found closing tag
-				return
-			}
-			depth--
-			continue
-		}
+	if b[1] != '/' {
+		return pc.text(b, 1+textLength(b[1:]))
+	}
 
-		// skip over to end
-		for ; !pc.end; pc.next() {
-			if pc.now() != '>' {
-				continue
-			}
-			break
+	// the name of the end tag has to be followed
+	// by whatever ends it to tell it apart
+	n := 2
+	for n < len(b) && !markup.IsSpace(b[n]) && b[n] != '/' && b[n] != '>' {
+		n++
+	}
+	if n == len(b) {
+		if !pc.eof {
+			return 0
 		}
+		return pc.text(b, len(b))
+	}
+	if !pc.is(len(pc.stack)-1, b[2:n], lookupName(b[2:n])) {
+		return pc.text(b, 1+textLength(b[1:]))
+	}
 
-		if pc.before() == '/' {
-			continue
+	if n = tokenLength(b, false); n == 0 {
+		if !pc.eof {
+			return 0
 		}
-
-		depth++
+		return pc.text(b, len(b))
 	}
-}
 
-func (q queryPath) Type() string {
-	key, _ := splitStringOnEqual(string(q))
-	return key
+	pc.rawText = false
+	pc.closeElements(len(pc.stack)-1, pc.pos, pc.pos+n)
+	pc.dispatch(EndTagToken, b[:n])
+	return n
 }
 
-func (q queryPath) Match(value []byte) bool {
+// text handles the text spanning the first n bytes of b.
+func (pc *parseContext) text(b []byte, n int) int {
+	pc.dispatch(TextToken, b[:n])
+	return n
+}
 
-	if len(value) == 0 {
-		return false
+// textLength returns the length of the text
+// at the start of b.
+func textLength(b []byte) int {
+	if i := bytes.IndexByte(b, '<'); i >= 0 {
+		return i
 	}
+	return len(b)
+}
 
-	qk, qv := q.kv()
+// tokenLength returns the length of the markup at the start
+// of b or zero in case b doesn't hold all of it. CDATA sections
+// last until "]]>" if cdata is set, as they do in foreign content,
+// elsewhere they are bogus comments ending at the first '>'.
+func tokenLength(b []byte, cdata bool) int {
+	if len(b) < 2 || (b[1] == '!' && len(b) < len(cdataOpener) &&
+		(bytes.HasPrefix(commentOpener, b) || (cdata && bytes.HasPrefix(cdataOpener, b)))) {
+		return 0
+	}
 
-	switch qk {
-	case "tag":
-		return qv == string(value)
-	case "id", "class":
-		_, val := splitBytesOnEqual(value)
-		return qv == string(stripValueParentheses(val))
+	switch {
+	case bytes.HasPrefix(b, commentOpener):
+		return commentLength(b)
+	case cdata && bytes.HasPrefix(b, cdataOpener):
+		i := bytes.Index(b[len(cdataOpener):], cdataCloser)
+		if i < 0 {
+			return 0
+		}
+		return len(cdataOpener) + i + len(cdataCloser)
+	case b[1] == '/' || isLetter(b[1]):
+		// quoted attribute values may hold '>', tags
+		// without quotes end at the first one
+		if i := bytes.IndexByte(b, '>'); i >= 0 &&
+			bytes.IndexByte(b[:i], '"') < 0 && bytes.IndexByte(b[:i], '\'') < 0 {
+			return i + 1
+		}
+		attrs := markup.ScanAttributes(b)
+		for attrs.Next() {
+		}
+		if attrs.Pos >= len(b) {
+			return 0
+		}
+		return attrs.Pos + 1
+	case b[1] == '!' || b[1] == '?':
+		i := bytes.IndexByte(b, '>')
+		if i < 0 {
+			return 0
+		}
+		return i + 1
 	default:
-		return false
+		// a stray '<' is plain text
+		return 1
 	}
 }
 
-// untilNextOpen skips until the next open tag
-// and returns if its ok to continue
-func untilNextOpen(pc *parseContext) {
-	for ; !pc.end; pc.next() {
-		if pc.now() != '<' {
-			continue
+// commentLength returns the length of the comment at the start
+// of b or zero in case b doesn't hold all of it. Comments end with
+// "-->" or "--!>", except the empty "<!-->" and "<!--->" ones, so
+// conditional comments are comments like any other.
+func commentLength(b []byte) int {
+	n := len(commentOpener)
+	switch {
+	case len(b) < n+2:
+		if len(b) > n && b[n] == '>' {
+			return n + 1
 		}
-		return
+		return 0
+	case b[n] == '>':
+		return n + 1
+	case b[n] == '-' && b[n+1] == '>':
+		return n + 2
 	}
-}
 
-func untilHtmlTagOpen(pc *parseContext) {
-	for ; !pc.end; pc.next() {
-		if pc.now() == '<' && pc.following() == 'h' {
-			break
+	for {
+		i := bytes.Index(b[n:], commentDashes)
+		if i < 0 {
+			return 0
+		}
+		n += i + len(commentDashes)
+
+		switch {
+		case n >= len(b) || (b[n] == '!' && n+1 >= len(b)):
+			return 0
+		case b[n] == '>':
+			return n + 1
+		case b[n] == '!' && b[n+1] == '>':
+			return n + 2
 		}
+		n--
 	}
 }
 
-func seekToEnd(pc *parseContext) {
-	for ; !pc.end; pc.next() {
-	}
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
 }
 
-func untilNextEnd(pc *parseContext) {
-	for ; !pc.end; pc.next() {
-		if pc.now() != '>' {
-			continue
-		}
-		return
-	}
+// tagName returns the name of the given start tag.
+func tagName(tag []byte) []byte {
+	return tag[1:markup.TagNameEnd(tag)]
 }
 
-var skippableTags = [][]byte{[]byte("script"), []byte("style"), []byte("noscript")}
+// endTagName returns the name of the given end tag.
+func endTagName(tag []byte) []byte {
+	return tag[2:markup.TagNameEnd(tag)]
+}
 
-func shouldTagContentBeSkipped(pc *parseContext) bool {
-	for _, tag := range skippableTags {
-		if bytes.Equal(pc.generalBuffer, tag) {
-			return true
+// startTag handles the given start tag spanning from start to end
+// in the buffer, trying to match it to each of the rules if match is set.
+func (pc *parseContext) startTag(tag []byte, start, end int, match bool) {
+	name := tagName(tag)
+	a := lookupName(name)
+	kind := kindOf(a)
+
+	// elements whose end tag is optional are
+	// ended by the start tags of some others
+	for len(pc.stack) > 0 {
+		top := pc.stack[len(pc.stack)-1]
+		if top.kind&impliedEnd == 0 || !impliedEnds[[2]atom.Atom{top.atom, a}] {
+			break
 		}
+		pc.closeElements(len(pc.stack)-1, start, start)
 	}
-	return false
-}
 
-func seekMatchingTagEnd(pc *parseContext, path queryPath) {
-	// start of tag
-	if pc.now() != '<' {
-		panic(errors.New("invalid element start"))
+	// "/>" only ends foreign elements, html ones stay open
+	void := kind&voidElement != 0 || (tag[len(tag)-2] == '/' && pc.inForeignContent(kind) && selfClosing(tag))
+	pc.advanceSelectors(tag, name)
+
+	if match && pc.skipDepth < 0 {
+		pc.opened = pc.opened[:0]
+		for i := range pc.rules {
+			if pc.matches[i].matched || (void && pc.rules[i].Action.needsContent()) {
+				continue
+			}
+			if pc.matchRule(i, tag, name) {
+				pc.opened = append(pc.opened, i)
+			}
+		}
+		if len(pc.opened) > 0 {
+			pc.open(tag, start, end, void)
+		}
 	}
 
-	// skip over closing tag to next element
-	if pc.following() == '/' {
-		pc.next()
-		untilNextOpen(pc)
-		seekMatchingTagEnd(pc, path)
+	if void {
 		return
 	}
 
-	// opening tag
-
-	// iterate over tag name
-
-	// clean out buffer
-	pc.resetGeneralBuffer()
+	pc.rawText = kind&textElement != 0
+	pc.push(name, a, kind)
+	pc.states = append(pc.states, pc.current...)
+}
 
-	for ; !pc.end; pc.next() {
-		// tag closed with no info
-		if pc.now() == '>' {
+// selfClosing reports whether the start tag ends with "/>", which
+// a '/' ending an unquoted attribute value doesn't count as.
+func selfClosing(tag []byte) bool {
+	attrs := markup.ScanAttributes(tag)
+	for attrs.Next() {
+	}
+	return attrs.SelfClosing
+}
 
-			// in case of no attributes we try to match the buffer here
-			if path.Type() == "tag" && path.Match(pc.generalBuffer) {
-				untilNextEnd(pc)
-				return
-			}
+// inForeignContent reports whether the element of the
+// given kind is or is nested in a foreign element.
+func (pc *parseContext) inForeignContent(kind elementKind) bool {
+	if kind&foreignElement != 0 {
+		return true
+	}
+	for _, open := range pc.stack {
+		if open.kind&foreignElement != 0 {
+			return true
+		}
+	}
+	return false
+}
 
-			untilNextOpen(pc)
-			seekMatchingTagEnd(pc, path)
+// endTag handles the end tag of the element with the given name
+// spanning from start to end in the buffer, closing the innermost
+// open element of that name. end tags of no open element are
+// ignored.
+func (pc *parseContext) endTag(name []byte, start, end int) {
+	a := lookupName(name)
+	for depth := len(pc.stack) - 1; depth >= 0; depth-- {
+		if pc.is(depth, name, a) {
+			pc.closeElements(depth, start, end)
 			return
 		}
-		// if not empty space skip
-		if pc.now() != ' ' {
-			// in case of a tag where we aren't currently
-			// on the tag opener we copy it over to the general buffer
-			if pc.now() != '<' && path.Type() == "tag" {
-				pc.generalBuffer = append(pc.generalBuffer, pc.now())
+	}
+}
+
+// closeElements closes the open elements from the given depth up.
+// the one at depth is closed by the tag spanning from start to end
+// in the buffer, those nested in it are implicitly closed at start.
+func (pc *parseContext) closeElements(depth int, start, end int) {
+	for d := len(pc.stack) - 1; d >= depth; d-- {
+		tagEnd := start
+		if d == depth {
+			tagEnd = end
+		}
+
+		for i := range pc.matches {
+			if m := &pc.matches[i]; m.open && m.depth == d {
+				m.open = false
+				pc.close(i, start, tagEnd)
 			}
-			continue
 		}
-		// break on first empty space
-		break
 	}
+	pc.pop(depth)
+	pc.states = pc.states[:depth*len(pc.selectors)]
+}
 
-	// in case of attributes we try to match the buffer first
-	if path.Type() == "tag" && path.Match(pc.generalBuffer) {
-		untilNextEnd(pc)
-		return
+// open applies the opened rules to the element whose start tag
+// spans from start to end in the buffer. the attributes are set
+// first so the other rules work on the rewritten tag.
+func (pc *parseContext) open(tag []byte, start, end int, void bool) {
+	for _, i := range pc.opened {
+		pc.matches[i] = match{matched: true, open: !void, depth: len(pc.stack), position: pc.at()}
 	}
 
-	//TODO: investigate why this is not used
-
-	// check if tag content should be skipped
-	//if shouldTagContentBeSkipped(pc) {
-	//	untilNextEnd(pc)
-	//	untilNextOpen(pc)
-	//	seekMatchingTagEnd(pc, path)
-	//	return
-	//}
+	// a removed element takes the other rules matching it along
+	for _, i := range pc.opened {
+		if pc.rules[i].Action != RemoveAction {
+			continue
+		}
 
-	// iterate over tag attributes
-	for ; !pc.end; pc.next() {
+		for _, j := range pc.opened {
+			pc.matches[j].open = j == i && !void
+		}
 
-		// arrived at tag end
-		if pc.now() == '>' {
-			untilNextOpen(pc)
-			seekMatchingTagEnd(pc, path)
+		pc.flush(start)
+		if void {
+			pc.discard(end)
 			return
 		}
+		pc.skipWrite = true
+		pc.skipDepth = len(pc.stack)
+		return
+	}
 
-		// space - skip ahead
-		if pc.now() == ' ' {
+	// the rewritten tag alternates between two buffers so
+	// each attribute is set on the result of the previous one
+	rewritten, n := tag, 0
+	for _, i := range pc.opened {
+		rule := pc.rules[i]
+		if rule.Action != AttrAction {
 			continue
 		}
 
-		// attr found
-		pc.resetGeneralBuffer()
-		for ; !pc.end; pc.next() {
+		pc.matches[i].open = false
+		pc.tagBuf[n%2] = appendAttribute(pc.tagBuf[n%2][:0], rewritten, rule.Attribute, rule.Value)
+		rewritten = pc.tagBuf[n%2]
+		n++
+	}
+	if n > 0 {
+		pc.flush(start)
+		pc.output(rewritten)
+		pc.discard(end)
+	}
 
-			pc.generalBuffer = append(pc.generalBuffer, pc.now())
+	for _, i := range pc.opened {
+		rule := pc.rules[i]
+		switch rule.Action {
+		case SetAction:
+			pc.flush(end)
+			pc.output(unsafeGetBytes(rule.Value))
+			pc.skipWrite = true
+			pc.skipDepth = len(pc.stack)
+		case PrependAction:
+			pc.flush(end)
+			pc.output(unsafeGetBytes(rule.Value))
+		}
+	}
+}
 
-			// in case the following rune is a space or a tag
-			// closer we try to match the attr stored in the
-			// general buffer.
-			if pc.following() == ' ' || pc.following() == '>' {
+// close applies the rule at index i to the element whose
+// end tag spans from start to end in the buffer.
+func (pc *parseContext) close(i int, start, end int) {
+	rule := pc.rules[i]
+	switch rule.Action {
+	case SetAction:
+		pc.discard(start)
+		pc.skipWrite = false
+		pc.skipDepth = -1
+	case AppendAction:
+		pc.flush(start)
+		pc.output(unsafeGetBytes(rule.Value))
+	case RemoveAction:
+		pc.discard(end)
+		pc.skipWrite = false
+		pc.skipDepth = -1
+	}
+}
 
-				if path.Match(pc.attribute()) {
-					untilNextEnd(pc)
-					return
-				}
+var (
+	attributeValueOpener = []byte("=\"")
+	attributeValueCloser = []byte("\"")
+	attributeSeparator   = []byte(" ")
+)
 
-				break
-			}
+// appendAttribute appends the given start tag to dst with the attribute
+// set to the escaped value, either replacing its current value or adding it.
+func appendAttribute(dst, tag []byte, name, value string) []byte {
+	attrs := markup.ScanAttributes(tag)
+	insertAt, resumeAt := attrs.Pos, attrs.Pos
+	for attrs.Next() {
+		if equalFold(attrs.Attr.Key(tag), name) {
+			insertAt, resumeAt = attrs.Attr.KeyStart, attrs.Attr.End
+			break
 		}
+		insertAt, resumeAt = attrs.Attr.End, attrs.Attr.End
 	}
-}
 
-func newParseCtx(r io.Reader, w io.Writer) *parseContext {
-	return &parseContext{
-		r:             r,
-		w:             w,
-		runeBuffer:    [3]byte{},
-		generalBuffer: make([]byte, 0, 2048),
-		end:           false,
-		skipWrite:     false,
-		i:             0,
+	dst = append(dst, tag[:insertAt]...)
+	if insertAt == resumeAt {
+		dst = append(dst, attributeSeparator...)
 	}
+	dst = append(dst, name...)
+	dst = append(dst, attributeValueOpener...)
+	dst = append(dst, value...)
+	dst = append(dst, attributeValueCloser...)
+	return append(dst, tag[resumeAt:]...)
 }
 
-var closingTag = []byte("<")
+type queryPath string
+
+func (q queryPath) kv() (string, string) {
+	return splitStringOnEqual(string(q))
+}
 
-func withCtx(r io.Reader, w io.Writer, f func(pc *parseContext) error) (err error) {
-	pc := defaultPool.Get(r, w)
-	defer defaultPool.Put(pc)
+func (q queryPath) Type() string {
+	key, _ := splitStringOnEqual(string(q))
+	return key
+}
 
-	defer func() {
-		if r := recover(); r != nil {
+// Match reports whether the path matches the given tag name,
+// or the given key=value attribute for the other path types.
+func (q queryPath) Match(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
 
-			err = r.(error)
+	if q.Type() == "tag" {
+		_, qv := q.kv()
+		return equalFold(value, qv)
+	}
 
-			// finish r/w operations on panic
-			// in case were not at end of file
-			// or context of read ended
-			if !pc.end && err.Error() != "EOF" {
-				seekToEnd(pc)
-			}
-		}
-	}()
+	if bytes.IndexByte(value, '=') < 0 {
+		return false
+	}
+	key, val := splitBytesOnEqual(value)
+	qk, qv := q.kv()
+	return matchAttribute(qk, qv, key, stripValueParentheses(val), false)
+}
 
-	return f(pc)
+// matchAttribute reports whether the qk=qv matcher matches the
+// attribute with the given key and unquoted value, keys are
+// matched regardless of their case and values only when folding.
+func matchAttribute(qk, qv string, key, value []byte, fold bool) bool {
+	switch qk {
+	case "id":
+		return equalFold(key, "id") && equalValue(value, qv, fold)
+	case "class":
+		return equalFold(key, "class") && hasClass(value, qv, fold)
+	default:
+		return false
+	}
 }
 
-func Append(r io.Reader, w io.Writer, path, value string) error {
+// matchRule reports whether the given start tag matches the path
+// of the rule, css paths are matched by the state of their selectors.
+func (pc *parseContext) matchRule(i int, tag, name []byte) bool {
+	path := queryPath(pc.rules[i].Path)
+	if !strings.HasPrefix(string(path), "css=") {
+		return matchTag(tag, name, path, pc.config.FoldValues)
+	}
 
-	if len(value) == 0 || value[0] != '<' {
-		return errors.New("value must start with an html open tag '<'")
+	for j, s := range pc.selectors {
+		if s.rule == i && s.sel.matched(pc.current[j]) {
+			return true
+		}
 	}
+	return false
+}
 
-	return withCtx(r, w, func(pc *parseContext) (err error) {
-		untilHtmlTagOpen(pc)
-		seekMatchingTagEnd(pc, queryPath(path))
-		untilCurrentTagCloseTagStart(pc)
+// matchTag checks whether the given start tag matches one of the
+// comma separated matchers of the path, folding the case of
+// attribute values if fold is set.
+func matchTag(tag, name []byte, path queryPath, fold bool) bool {
+	for {
+		matcher := path
+		end := strings.IndexByte(string(path), ',')
+		if end >= 0 {
+			matcher = path[:end]
+		}
+		if matchMatcher(tag, name, matcher, fold) {
+			return true
+		}
+		if end < 0 {
+			return false
+		}
+		path = path[end+1:]
+	}
+}
 
-		// write the value while omitting the first tag opener
-		//TODO: fix issue with tag opener written to 'w' because of how
-		// untilCurrentTagCloseTagStart exit condition.
-		if _, err = pc.w.Write(unsafeGetBytes(value[1:])); err != nil {
-			return
+// matchMatcher checks whether the given start tag matches one matcher.
+func matchMatcher(tag, name []byte, path queryPath, fold bool) bool {
+	key, value := path.kv()
+	if key == "tag" {
+		return equalFold(name, value)
+	}
+	// values are matched as they are written, so a tag
+	// not holding the value has no attribute matching it
+	if !fold && !bytes.Contains(tag, unsafeGetBytes(value)) {
+		return false
+	}
+
+	attrs := markup.ScanAttributes(tag)
+	for attrs.Next() {
+		// attributes with no value can't be matched
+		if !attrs.Attr.HasValue {
+			continue
 		}
-		if _, err = pc.w.Write(closingTag); err != nil {
-			return
+
+		if matchAttribute(key, value, attrs.Attr.Key(tag), attrs.Attr.Value(tag), fold) {
+			return true
 		}
+	}
+	return false
+}
 
-		seekToEnd(pc)
-		return
-	})
+func newParseCtx(r io.Reader, w io.Writer) *parseContext {
+	return &parseContext{
+		r:         r,
+		w:         w,
+		buffer:    make([]byte, 0, readBufferSize),
+		rules:     make([]Rule, 0, 4),
+		matches:   make([]match, 0, 4),
+		skipDepth: -1,
+	}
 }
 
-func Set(r io.Reader, w io.Writer, path string, value string) error {
-	return withCtx(r, w, func(pc *parseContext) (err error) {
-		untilHtmlTagOpen(pc)
-		seekMatchingTagEnd(pc, queryPath(path))
+// Rewrite applies the given rules to the html read
+// from r while writing the result to w.
+func Rewrite(r io.Reader, w io.Writer, rules ...Rule) error {
+	return Config{}.Rewrite(r, w, rules...)
+}
 
-		if _, err = w.Write(unsafeGetBytes(value)); err != nil {
-			return
-		}
+// Match reports the outcome of a rule.
+type Match struct {
+	// Matched is set when the rule was
+	// applied to an element.
+	Matched bool
+	// Position is where the start tag of
+	// the element starts in the input.
+	Position model.Position
+}
 
-		pc.skipWrite = true
-		untilCurrentTagCloseTagStart(pc)
+// RewriteMatches works like Rewrite and also reports the
+// outcome of each of the rules, indexed like the rules.
+// The matches are returned along with any error, rules
+// that matched nothing can be found through them.
+func RewriteMatches(r io.Reader, w io.Writer, rules ...Rule) ([]Match, error) {
+	return Config{}.RewriteMatches(r, w, rules...)
+}
 
-		// we need to add the closing tag ourselves
-		if _, err = pc.w.Write(closingTag); err != nil {
-			return
-		}
+func Append(r io.Reader, w io.Writer, path, value string) error {
+	return Rewrite(r, w, AppendRule(path, value))
+}
 
-		pc.skipWrite = false
-		seekToEnd(pc)
+func Prepend(r io.Reader, w io.Writer, path, value string) error {
+	return Rewrite(r, w, PrependRule(path, value))
+}
 
-		return
-	})
+func Set(r io.Reader, w io.Writer, path string, value string) error {
+	return Rewrite(r, w, SetRule(path, value))
+}
+
+func Remove(r io.Reader, w io.Writer, path string) error {
+	return Rewrite(r, w, RemoveRule(path))
+}
+
+func SetAttr(r io.Reader, w io.Writer, path, name, value string) error {
+	return Rewrite(r, w, AttrRule(path, name, value))
 }
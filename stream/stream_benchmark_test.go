@@ -1,6 +1,8 @@
 package stream
 
 import (
+	"bytes"
+	_ "embed"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -13,18 +15,19 @@ func BenchmarkNewParseCtx(b *testing.B) {
 	}
 }
 
-func BenchmarkSeekTagEnd(b *testing.B) {
-	pc := &parseContext{
-		r:             strings.NewReader("<html><body><div id=\"meow\"></div></body></html>"),
-		w:             ioutil.Discard,
-		runeBuffer:    [3]byte{0, '<', 'h'},
-		generalBuffer: make([]byte, 1024),
-		end:           false,
-		skipWrite:     false,
-		i:             1,
-	}
+func BenchmarkProcess(b *testing.B) {
+	input := []byte("<html><body><div id=\"meow\"></div></body></html>")
+	rules := []Rule{SetRule("id=meow", "value")}
+	r := bytes.NewReader(input)
+	pc := newParseCtx(r, ioutil.Discard)
 	b.ResetTimer()
-	seekMatchingTagEnd(pc, "id=meow")
+	for n := 0; n < b.N; n++ {
+		r.Reset(input)
+		pc.reset(r, ioutil.Discard)
+		pc.setup(Config{}, rules)
+		pc.fill()
+		pc.process()
+	}
 	b.ReportAllocs()
 }
 
@@ -35,21 +38,27 @@ func BenchmarkQueryPath(b *testing.B) {
 		value := []byte("id=meow")
 
 		b.ResetTimer()
-		queryPath.Match(value)
+		for n := 0; n < b.N; n++ {
+			queryPath.Match(value)
+		}
 		b.ReportAllocs()
 	})
 
 	b.Run("Type", func(b *testing.B) {
 		queryPath := queryPath("id=meow")
 		b.ResetTimer()
-		queryPath.Type()
+		for n := 0; n < b.N; n++ {
+			queryPath.Type()
+		}
 		b.ReportAllocs()
 	})
 
 	b.Run("kv", func(b *testing.B) {
 		queryPath := queryPath("id=meow")
 		b.ResetTimer()
-		_, _ = queryPath.kv()
+		for n := 0; n < b.N; n++ {
+			_, _ = queryPath.kv()
+		}
 		b.ReportAllocs()
 	})
 }
@@ -73,10 +82,39 @@ func BenchmarkSplitBytesOnEqual(b *testing.B) {
 
 }
 
-func Benchmark_withCtx(b *testing.B) {
+func BenchmarkRewrite(b *testing.B) {
 	r := strings.NewReader("value")
-	_ = withCtx(r, ioutil.Discard, func(pc *parseContext) error {
-		return nil
-	})
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r.Reset("value")
+		_ = Rewrite(r, ioutil.Discard)
+	}
 	b.ReportAllocs()
 }
+
+//go:embed static_html/qlik-sense-hub.html
+var qlikSenseHubHTML []byte
+
+func BenchmarkStaticHTML(b *testing.B) {
+	injectedValue := "<script>alert(1)</script>"
+
+	b.Run("Append", func(b *testing.B) {
+		r := bytes.NewReader(qlikSenseHubHTML)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			r.Reset(qlikSenseHubHTML)
+			_ = Append(r, ioutil.Discard, "tag=head", injectedValue)
+		}
+		b.ReportAllocs()
+	})
+
+	b.Run("Set", func(b *testing.B) {
+		r := bytes.NewReader(qlikSenseHubHTML)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			r.Reset(qlikSenseHubHTML)
+			_ = Set(r, ioutil.Discard, "tag=head", injectedValue)
+		}
+		b.ReportAllocs()
+	})
+}
@@ -1,14 +1,18 @@
 package stream
 
 import (
+	"bufio"
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
+	"github.com/html-overwrite/model"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 const testSetHtmlTemplate = `
@@ -191,3 +195,600 @@ func TestQueryPath_Match(t *testing.T) {
 		assert.False(t, invalidPath.Match([]byte("id=3")))
 	})
 }
+
+func TestSeveralMatchers(t *testing.T) {
+	const document = `<html><body><div class="a">x</div><p id="b">y</p><span>z</span></body></html>`
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "id=b,class=a", expected: `<div class="a">new</div><p id="b">y</p>`},
+		{path: "id=b,tag=span", expected: `<div class="a">x</div><p id="b">new</p>`},
+		{path: "tag=span,id=missing", expected: `<p id="b">y</p><span>new</span>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			assert.Nil(t, Rewrite(strings.NewReader(document), buffer, SetRule(test.path, "new")))
+			assert.Contains(t, buffer.String(), test.expected)
+		})
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		err := Rewrite(strings.NewReader(document), io.Discard, SetRule("id=c,class=b", "new"))
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+// emptyReader never returns data nor an error.
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) {
+	return 0, nil
+}
+
+// errWriter fails every write.
+type errWriter struct{ err error }
+
+func (e *errWriter) Write([]byte) (int, error) {
+	return 0, e.err
+}
+
+func TestReaderContract(t *testing.T) {
+	appendedTag := "<h2>Example Sub Header</h2>"
+
+	readers := map[string]func(r io.Reader) io.Reader{
+		"one byte":  iotest.OneByteReader,
+		"half":      iotest.HalfReader,
+		"data err":  iotest.DataErrReader,
+		"short buf": func(r io.Reader) io.Reader { return bufio.NewReaderSize(r, 16) },
+	}
+
+	for name, wrap := range readers {
+		t.Run(name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			err := Append(wrap(strings.NewReader(testAppendHtmlTemplate)), buffer, "id=headers", appendedTag)
+			assert.Nil(t, err)
+			equalStripped(t, testPostAppendHtmlTemplate, buffer.String())
+
+			buffer.Reset()
+			err = Set(wrap(strings.NewReader(testSetHtmlTemplate)), buffer, "id=meow", "value")
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf(testSetHtmlTemplate, "value"), buffer.String())
+		})
+	}
+
+	t.Run("timeout after data", func(t *testing.T) {
+		// the data read before the timeout is still rewritten
+		// and the timeout is reported to the caller
+		buffer := &bytes.Buffer{}
+		r := iotest.TimeoutReader(strings.NewReader(testAppendHtmlTemplate))
+		err := Append(r, buffer, "id=headers", appendedTag)
+		assert.True(t, errors.Is(err, iotest.ErrTimeout))
+		equalStripped(t, testPostAppendHtmlTemplate, buffer.String())
+	})
+
+	t.Run("error before match", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		failure := errors.New("random failure")
+		r := io.MultiReader(strings.NewReader("<html><body>"), iotest.ErrReader(failure))
+		err := Append(r, buffer, "id=headers", appendedTag)
+		assert.True(t, errors.Is(err, failure))
+		assert.Equal(t, "<html><body>", buffer.String())
+	})
+
+	t.Run("unexpected eof", func(t *testing.T) {
+		truncated := testAppendHtmlTemplate[:strings.Index(testAppendHtmlTemplate, "</div>")]
+
+		err := Append(strings.NewReader(truncated), io.Discard, "id=headers", appendedTag)
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+		err = Set(strings.NewReader(truncated), io.Discard, "id=headers", appendedTag)
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+
+	t.Run("no progress", func(t *testing.T) {
+		err := Append(emptyReader{}, io.Discard, "id=headers", appendedTag)
+		assert.True(t, errors.Is(err, io.ErrNoProgress))
+	})
+
+	t.Run("failing writer", func(t *testing.T) {
+		failure := errors.New("random failure")
+		err := Set(strings.NewReader(testSetHtmlTemplate), &errWriter{failure}, "id=meow", "value")
+		assert.True(t, errors.Is(err, failure))
+	})
+}
+
+func TestActions(t *testing.T) {
+	const document = `<html><head><meta charset="utf-8"></head><body><div id="content"><p>text</p></div><img id="logo" src="a.png"/></body></html>`
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		expected string
+	}{
+		{
+			name:     "prepend",
+			rule:     PrependRule("id=content", "<h1>title</h1>"),
+			expected: `<html><head><meta charset="utf-8"></head><body><div id="content"><h1>title</h1><p>text</p></div><img id="logo" src="a.png"/></body></html>`,
+		},
+		{
+			name:     "remove",
+			rule:     RemoveRule("id=content"),
+			expected: `<html><head><meta charset="utf-8"></head><body><img id="logo" src="a.png"/></body></html>`,
+		},
+		{
+			name:     "remove void",
+			rule:     RemoveRule("id=logo"),
+			expected: `<html><head><meta charset="utf-8"></head><body><div id="content"><p>text</p></div></body></html>`,
+		},
+		{
+			name:     "replace attribute",
+			rule:     AttrRule("id=logo", "src", "b.png"),
+			expected: `<html><head><meta charset="utf-8"></head><body><div id="content"><p>text</p></div><img id="logo" src="b.png"/></body></html>`,
+		},
+		{
+			name:     "add attribute",
+			rule:     AttrRule("id=content", "class", `"quoted" & escaped`),
+			expected: `<html><head><meta charset="utf-8"></head><body><div id="content" class="&#34;quoted&#34; &amp; escaped"><p>text</p></div><img id="logo" src="a.png"/></body></html>`,
+		},
+		{
+			name:     "add attribute to void",
+			rule:     AttrRule("id=logo", "alt", "logo"),
+			expected: `<html><head><meta charset="utf-8"></head><body><div id="content"><p>text</p></div><img id="logo" src="a.png" alt="logo"/></body></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			err := Rewrite(strings.NewReader(document), buffer, test.rule)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, buffer.String())
+		})
+	}
+
+	t.Run("content of void element", func(t *testing.T) {
+		err := Set(strings.NewReader(document), io.Discard, "tag=meta", "text")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("same element", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			rules    []Rule
+			expected string
+		}{
+			{
+				name: "set and attributes",
+				rules: []Rule{
+					SetRule("id=content", "<p>new</p>"),
+					AttrRule("id=content", "class", "main"),
+					AttrRule("id=content", "id", "main"),
+				},
+				expected: `<html><head><meta charset="utf-8"></head><body><div id="main" class="main"><p>new</p></div><img id="logo" src="a.png"/></body></html>`,
+			},
+			{
+				name: "prepend and append",
+				rules: []Rule{
+					AppendRule("id=content", "<p>last</p>"),
+					PrependRule("id=content", "<p>first</p>"),
+				},
+				expected: `<html><head><meta charset="utf-8"></head><body><div id="content"><p>first</p><p>text</p><p>last</p></div><img id="logo" src="a.png"/></body></html>`,
+			},
+			{
+				name: "remove",
+				rules: []Rule{
+					AttrRule("id=content", "class", "main"),
+					AppendRule("id=content", "<p>last</p>"),
+					RemoveRule("id=content"),
+				},
+				expected: `<html><head><meta charset="utf-8"></head><body><img id="logo" src="a.png"/></body></html>`,
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				buffer := &bytes.Buffer{}
+				err := Rewrite(strings.NewReader(document), buffer, test.rules...)
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, buffer.String())
+			})
+		}
+	})
+}
+
+func TestRewriteMatches(t *testing.T) {
+	const document = `<html><head></head><body><div id="content"></div></body></html>`
+
+	buffer := &bytes.Buffer{}
+	matches, err := RewriteMatches(strings.NewReader(document), buffer,
+		SetRule("id=content", "text"),
+		Rule{Action: RemoveAction, Path: "id=banner", Optional: true},
+		RemoveRule("id=missing"),
+	)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, []Match{{Matched: true, Position: model.Position{Offset: 25, Line: 1, Column: 26}}, {}, {}}, matches)
+	assert.Equal(t, `<html><head></head><body><div id="content">text</div></body></html>`, buffer.String())
+}
+
+func TestElements(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		rule     Rule
+		expected string
+	}{
+		{
+			name:     "void elements",
+			body:     `<div id="a"><img src="a.png"><br><input type="text"><hr><source src="a"><wbr>text</div><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id="a">new</div><p>after</p>`,
+		},
+		{
+			name:     "uppercase void element",
+			body:     `<div id="a"><IMG src="a.png"></div><p>after</p>`,
+			rule:     AppendRule("id=a", "<b>new</b>"),
+			expected: `<div id="a"><IMG src="a.png"><b>new</b></div><p>after</p>`,
+		},
+		{
+			name:     "list item",
+			body:     `<ul><li id="a">one<li>two</ul>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<ul><li id="a">new<li>two</ul>`,
+		},
+		{
+			name:     "last list item",
+			body:     `<ul><li>one<li id="a">two</ul><p>after</p>`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<ul><li>one<li id="a">two<b>!</b></ul><p>after</p>`,
+		},
+		{
+			name:     "removed list item",
+			body:     `<ul><li id="a">one<li>two</ul>`,
+			rule:     RemoveRule("id=a"),
+			expected: `<ul><li>two</ul>`,
+		},
+		{
+			name:     "paragraph",
+			body:     `<p id="a">text<div>block</div>`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<p id="a">text<b>!</b><div>block</div>`,
+		},
+		{
+			name:     "table cells",
+			body:     `<table><tr><td id="a">1<td>2<tr><td>3</table>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<table><tr><td id="a">new<td>2<tr><td>3</table>`,
+		},
+		{
+			name:     "table row",
+			body:     `<table><tr id="a"><td>1<td>2<tr><td>3</table>`,
+			rule:     RemoveRule("id=a"),
+			expected: `<table><tr><td>3</table>`,
+		},
+		{
+			name:     "options",
+			body:     `<select><option id="a">1<option>2</select>`,
+			rule:     AttrRule("id=a", "selected", "selected"),
+			expected: `<select><option id="a" selected="selected">1<option>2</select>`,
+		},
+		{
+			name:     "unclosed child",
+			body:     `<div id="a"><span>unclosed</div><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id="a">new</div><p>after</p>`,
+		},
+		{
+			name:     "empty value",
+			body:     `<div id="a"><p>x</p></div>`,
+			rule:     SetRule("id=a", ""),
+			expected: `<div id="a"></div>`,
+		},
+		{
+			// the name shared the 32-bit FNV-1a hash of br
+			name:     "custom element",
+			body:     `<x-syekbwb id="a"><span>x</span></x-syekbwb><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<x-syekbwb id="a">new</x-syekbwb><p>after</p>`,
+		},
+		{
+			name:     "custom element end tags",
+			body:     `<X-Card id="a"><x-card>x</x-card>y</x-CARD><x-cards>z</x-cards>`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<X-Card id="a"><x-card>x</x-card>y<b>!</b></x-CARD><x-cards>z</x-cards>`,
+		},
+		{
+			name:     "self-closing html element",
+			body:     `<div id="a"/><span>x</span></div><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id="a"/>new</div><p>after</p>`,
+		},
+		{
+			name:     "self-closing foreign element",
+			body:     `<svg><path d="1"/><path d="2"/></svg>`,
+			rule:     RemoveRule("tag=path"),
+			expected: `<svg><path d="2"/></svg>`,
+		},
+		{
+			name:     "stray end tag",
+			body:     `<div id="a"></span>text</div><p>after</p>`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<div id="a"></span>text<b>!</b></div><p>after</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			err := Rewrite(strings.NewReader("<html><body>"+test.body+"</body></html>"), buffer, test.rule)
+			assert.Nil(t, err)
+			assert.Equal(t, "<html><body>"+test.expected+"</body></html>", buffer.String())
+		})
+	}
+}
+
+func TestOptionalEnds(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		rule     Rule
+		expected string
+	}{
+		{
+			name:     "head ended by body",
+			document: `<html><head><title>t</title><body><p>x</p></body></html>`,
+			rule:     AppendRule("tag=head", "<script></script>"),
+			expected: `<html><head><title>t</title><script></script><body><p>x</p></body></html>`,
+		},
+		{
+			name:     "body ended by the input",
+			document: `<html><head></head><body><p>x`,
+			rule:     AppendRule("tag=body", "<script></script>"),
+			expected: `<html><head></head><body><p>x<script></script>`,
+		},
+		{
+			name:     "html ended by the input",
+			document: "<!DOCTYPE html>\n<html><body><p id=\"a\">x\n",
+			rule:     SetRule("tag=html", "<body></body>"),
+			expected: "<!DOCTYPE html>\n<html><body></body>",
+		},
+		{
+			name:     "paragraph ended by the input",
+			document: `<html><body><p id="a">x`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<html><body><p id="a">x<b>!</b>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, read := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.DataErrReader} {
+				buffer := &bytes.Buffer{}
+				assert.Nil(t, Rewrite(read(strings.NewReader(test.document)), buffer, test.rule))
+				assert.Equal(t, test.expected, buffer.String())
+			}
+		})
+	}
+
+	t.Run("required end", func(t *testing.T) {
+		err := Append(strings.NewReader(`<html><body><div id="a"><p>x`), io.Discard, "tag=body", "<b>!</b>")
+		assert.EqualError(t, err, `element matching "tag=body" opened at 1:7 was not closed: unexpected EOF`)
+	})
+}
+
+func TestTextElements(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		rule     Rule
+		expected string
+	}{
+		{
+			name:     "end tag in script",
+			body:     `<div id="a"><script>document.write("</div>")</script></div><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div id="a">new</div><p>after</p>`,
+		},
+		{
+			name:     "start tag in script",
+			body:     `<script>let s = '<div id="a">'</script><div id="a">text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<script>let s = '<div id="a">'</script><div id="a">new</div>`,
+		},
+		{
+			name:     "longer end tag in script",
+			body:     `<script>a</scripts>b</script><div id="a">text</div>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<script>a</scripts>b</script><div id="a">new</div>`,
+		},
+		{
+			name:     "end tag with space",
+			body:     `<script id="a">a < b</SCRIPT >text`,
+			rule:     SetRule("id=a", "b < a"),
+			expected: `<script id="a">b < a</SCRIPT >text`,
+		},
+		{
+			name:     "style",
+			body:     `<style>div > p { color: red }</style><p id="a">text</p>`,
+			rule:     AppendRule("id=a", "<b>!</b>"),
+			expected: `<style>div > p { color: red }</style><p id="a">text<b>!</b></p>`,
+		},
+		{
+			name:     "textarea",
+			body:     `<textarea id="a"><p id="b">text</p></textarea><p id="b">text</p>`,
+			rule:     SetRule("id=b", "new"),
+			expected: `<textarea id="a"><p id="b">text</p></textarea><p id="b">new</p>`,
+		},
+		{
+			name:     "textarea content",
+			body:     `<textarea id="a"><p>text</p></textarea><p>after</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<textarea id="a">new</textarea><p>after</p>`,
+		},
+		{
+			name:     "title",
+			body:     `<title>a <b>c</b></title><b id="a">text</b>`,
+			rule:     RemoveRule("tag=b"),
+			expected: `<title>a <b>c</b></title>`,
+		},
+		{
+			name:     "cdata",
+			body:     `<svg><![CDATA[ a > <p id="a"> ]]></svg><p id="a">text</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<svg><![CDATA[ a > <p id="a"> ]]></svg><p id="a">new</p>`,
+		},
+		{
+			// a bogus comment outside of foreign content
+			name:     "cdata in html content",
+			body:     `<div><![CDATA[ a > <p id="a"> ]]></div><p id="a">text</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<div><![CDATA[ a > <p id="a">new</div><p id="a">text</p>`,
+		},
+		{
+			name:     "processing instruction",
+			body:     `<?php echo 1 ?><p id="a">text</p>`,
+			rule:     SetRule("id=a", "new"),
+			expected: `<?php echo 1 ?><p id="a">new</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, read := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader} {
+				buffer := &bytes.Buffer{}
+				err := Rewrite(read(strings.NewReader("<html><body>"+test.body+"</body></html>")), buffer, test.rule)
+				assert.Nil(t, err)
+				assert.Equal(t, "<html><body>"+test.expected+"</body></html>", buffer.String())
+			}
+		})
+	}
+
+	t.Run("prolog", func(t *testing.T) {
+		const prolog = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+`
+		buffer := &bytes.Buffer{}
+		err := Rewrite(strings.NewReader(prolog+`<html><body><p id="a">text</p></body></html>`), buffer, SetRule("id=a", "new"))
+		assert.Nil(t, err)
+		assert.Equal(t, prolog+`<html><body><p id="a">new</p></body></html>`, buffer.String())
+	})
+
+	t.Run("unterminated", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		err := Rewrite(strings.NewReader(`<html><body><p id="a">text</p><script>a </scr`), buffer, SetRule("id=a", "new"))
+		assert.Nil(t, err)
+		assert.Equal(t, `<html><body><p id="a">new</p><script>a </scr`, buffer.String())
+	})
+}
+
+//go:embed static_html/comments.html
+var commentsHTML string
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "commented out element",
+			body:     `<!-- <p id="a">old</p> --><p id="a">old</p>`,
+			expected: `<!-- <p id="a">old</p> --><p id="a">new</p>`,
+		},
+		{
+			name:     "arrow",
+			body:     `<!-- a -> <p id="a">old</p> --><p id="a">old</p>`,
+			expected: `<!-- a -> <p id="a">old</p> --><p id="a">new</p>`,
+		},
+		{
+			name:     "dashes",
+			body:     `<!-- a -- <p id="a">old</p> ---><p id="a">old</p>`,
+			expected: `<!-- a -- <p id="a">old</p> ---><p id="a">new</p>`,
+		},
+		{
+			name:     "bang",
+			body:     `<!-- a --!><p id="a">old</p>`,
+			expected: `<!-- a --!><p id="a">new</p>`,
+		},
+		{
+			name:     "empty",
+			body:     `<!----><!--><!---><p id="a">old</p>`,
+			expected: `<!----><!--><!---><p id="a">new</p>`,
+		},
+		{
+			name:     "conditional",
+			body:     `<!--[if IE]><p id="a">old</p><![endif]--><p id="a">old</p>`,
+			expected: `<!--[if IE]><p id="a">old</p><![endif]--><p id="a">new</p>`,
+		},
+		{
+			name:     "revealed conditional",
+			body:     `<!--[if !IE]><!--><p id="a">old</p><!--<![endif]-->`,
+			expected: `<!--[if !IE]><!--><p id="a">new</p><!--<![endif]-->`,
+		},
+		{
+			name:     "end tag in comment",
+			body:     `<div id="a"><!-- </div> -->old</div><p>after</p>`,
+			expected: `<div id="a">new</div><p>after</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, read := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader} {
+				buffer := &bytes.Buffer{}
+				err := Rewrite(read(strings.NewReader("<html><body>"+test.body+"</body></html>")), buffer, SetRule("id=a", "new"))
+				assert.Nil(t, err)
+				assert.Equal(t, "<html><body>"+test.expected+"</body></html>", buffer.String())
+			}
+		})
+	}
+
+	t.Run("fixture", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		err := Set(strings.NewReader(commentsHTML), buffer, "id=content", "new")
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Replace(commentsHTML, `<div id="content"></div>`, `<div id="content">new</div>`, 1), buffer.String())
+	})
+}
+
+func TestPositions(t *testing.T) {
+	const document = "<html>\n<body>\n  <div id=\"a\">\n\t<p class=\"b\">text</p>\n  </div>\n</body>\n</html>\n"
+
+	t.Run("matches", func(t *testing.T) {
+		for _, read := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader} {
+			matches, err := RewriteMatches(read(strings.NewReader(document)), io.Discard,
+				SetRule("class=b", "new"),
+				AttrRule("id=a", "title", "x"),
+				Rule{Action: RemoveAction, Path: "id=missing", Optional: true},
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, []Match{
+				{Matched: true, Position: model.Position{Offset: 30, Line: 4, Column: 2}},
+				{Matched: true, Position: model.Position{Offset: 16, Line: 3, Column: 3}},
+				{},
+			}, matches)
+		}
+	})
+
+	t.Run("unclosed element", func(t *testing.T) {
+		truncated := document[:strings.Index(document, "</div>")]
+		err := Set(strings.NewReader(truncated), io.Discard, "id=a", "new")
+		assert.EqualError(t, err, `element matching "id=a" opened at 3:3 was not closed: unexpected EOF`)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		failure := errors.New("random failure")
+		err := Set(io.MultiReader(strings.NewReader(document[:20]), iotest.ErrReader(failure)), io.Discard, "class=b", "new")
+		assert.EqualError(t, err, "failed to read input at 3:7: random failure")
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		failure := errors.New("random failure")
+		err := Set(strings.NewReader(document), &errWriter{failure}, "class=b", "new")
+		assert.True(t, errors.Is(err, failure))
+		assert.Regexp(t, `^failed to write output at \d+:\d+: random failure$`, err.Error())
+	})
+}
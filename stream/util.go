@@ -9,13 +9,17 @@ import (
 )
 
 func unsafeGetBytes(s string) []byte {
+	if len(s) == 0 {
+		// the data of an empty string may be nil
+		return nil
+	}
 	return (*[0x7fff0000]byte)(unsafe.Pointer(
 		(*reflect.StringHeader)(unsafe.Pointer(&s)).Data),
 	)[:len(s):len(s)]
 }
 
 func splitStringOnEqual(s string) (string, string) {
-	i := strings.Index(s, "=")
+	i := strings.IndexByte(s, '=')
 	if i < 0 {
 		panic(errors.New("failed to split key value"))
 	}
@@ -23,7 +27,7 @@ func splitStringOnEqual(s string) (string, string) {
 }
 
 func splitBytesOnEqual(s []byte) ([]byte, []byte) {
-	i := bytes.IndexRune(s, '=')
+	i := bytes.IndexByte(s, '=')
 	if i < 0 {
 		panic(errors.New("failed to split key value"))
 	}
@@ -37,3 +41,23 @@ func stripValueParentheses(b []byte) []byte {
 
 	return b[1 : len(b)-1]
 }
+
+// equalFold reports whether b and s are equal under ASCII case folding.
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range b {
+		if lowerASCII(b[i]) != lowerASCII(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"errors"
+	"io"
+)
+
+// writer pushes whatever is written to it through a
+// parse context which outputs to the destination.
+type writer struct {
+	pc *parseContext
+}
+
+// NewWriter returns a writer accepting html in chunks of any
+// size and writing it to dst with the given rules applied to it.
+// Close must be called once the whole document was written to
+// flush the rest of it, it doesn't close dst.
+func NewWriter(dst io.Writer, rules ...Rule) io.WriteCloser {
+	return Config{}.NewWriter(dst, rules...)
+}
+
+func (wr *writer) Write(p []byte) (int, error) {
+	if wr.pc == nil {
+		return 0, errors.New("write to a closed writer")
+	}
+
+	wr.pc.feed(p)
+	if err := wr.pc.err; err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (wr *writer) Close() error {
+	if wr.pc == nil {
+		return nil
+	}
+
+	pc := wr.pc
+	pc.eof = true
+	pc.process()
+	pc.finish()
+	err := pc.result()
+
+	defaultPool.Put(pc)
+	wr.pc = nil
+
+	return err
+}
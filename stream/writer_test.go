@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestNewWriter(t *testing.T) {
+	appendedTag := "<h2>Example Sub Header</h2>"
+
+	t.Run("single write", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		w := NewWriter(buffer, AppendRule("id=headers", appendedTag))
+		_, err := w.Write([]byte(testAppendHtmlTemplate))
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+		equalStripped(t, testPostAppendHtmlTemplate, buffer.String())
+	})
+
+	t.Run("chunked writes", func(t *testing.T) {
+		for _, size := range []int{1, 2, 7, readBufferSize + 1} {
+			t.Run(fmt.Sprint(size), func(t *testing.T) {
+				buffer := &bytes.Buffer{}
+				w := NewWriter(buffer, SetRule("id=meow", "value"))
+
+				document := []byte(testSetHtmlTemplate)
+				for len(document) > 0 {
+					n := size
+					if n > len(document) {
+						n = len(document)
+					}
+					written, err := w.Write(document[:n])
+					assert.Nil(t, err)
+					assert.Equal(t, n, written)
+					document = document[n:]
+				}
+
+				assert.Nil(t, w.Close())
+				assert.Equal(t, fmt.Sprintf(testSetHtmlTemplate, "value"), buffer.String())
+			})
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		tmpl := template.Must(template.New("page").Parse(
+			`<html><head></head><body><p id="greeting">{{.}}</p></body></html>`,
+		))
+
+		buffer := &bytes.Buffer{}
+		w := NewWriter(buffer, AppendRule("tag=head", "<script>alert(1)</script>"))
+		assert.Nil(t, tmpl.Execute(w, "Hello"))
+		assert.Nil(t, w.Close())
+		assert.Equal(t,
+			`<html><head><script>alert(1)</script></head><body><p id="greeting">Hello</p></body></html>`,
+			buffer.String(),
+		)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		w := NewWriter(buffer, AppendRule("id=missing", appendedTag))
+		_, err := w.Write([]byte(testAppendHtmlTemplate))
+		assert.Nil(t, err)
+		assert.True(t, errors.Is(w.Close(), ErrNotFound))
+		assert.Equal(t, testAppendHtmlTemplate, buffer.String())
+	})
+
+	t.Run("unterminated tag", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		w := NewWriter(buffer, AppendRule("tag=head", appendedTag))
+		_, err := w.Write([]byte("<html><head></head><body"))
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+		assert.True(t, strings.HasSuffix(buffer.String(), "<body"))
+	})
+
+	t.Run("failing destination", func(t *testing.T) {
+		failure := errors.New("random failure")
+		w := NewWriter(&errWriter{failure}, SetRule("id=meow", "value"))
+		_, err := w.Write([]byte(testSetHtmlTemplate))
+		assert.True(t, errors.Is(err, failure))
+		assert.True(t, errors.Is(w.Close(), failure))
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{}, SetRule("id=meow", "value"))
+		assert.NotNil(t, w.Close())
+		_, err := w.Write([]byte(testSetHtmlTemplate))
+		assert.NotNil(t, err)
+		assert.Nil(t, w.Close())
+	})
+}
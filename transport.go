@@ -0,0 +1,96 @@
+package rewrite
+
+import (
+	"fmt"
+	"github.com/html-overwrite/httpmw"
+	"github.com/html-overwrite/stream"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+// RuleSet holds rules applied to the responses
+// of requests matching its criteria.
+type RuleSet struct {
+	// URL is matched against the full request URL,
+	// every URL is matched when nil.
+	URL *regexp.Regexp
+	// ContentTypes are the media types of the responses
+	// the rules apply to, text/html is used when empty.
+	ContentTypes []string
+	// Rules are applied to the matched response bodies.
+	Rules []stream.Rule
+}
+
+func (rs *RuleSet) matches(res *http.Response) bool {
+	if rs.URL != nil && !rs.URL.MatchString(res.Request.URL.String()) {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	if len(rs.ContentTypes) == 0 {
+		return mediaType == "text/html"
+	}
+
+	for _, contentType := range rs.ContentTypes {
+		if mediaType == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Transport is an http.RoundTripper applying the rules of every
+// RuleSet matching a response to its body as it is read.
+type Transport struct {
+	// Base is the RoundTripper fetching the responses,
+	// http.DefaultTransport is used when nil.
+	Base http.RoundTripper
+	// RuleSets are matched against each of the responses.
+	RuleSets []RuleSet
+}
+
+// RoundTrip implements http.RoundTripper. Invalid
+// rules fail it before the request is sent.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for i := range t.RuleSets {
+		if err := stream.ValidateRules(t.RuleSets[i].Rules...); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, fmt.Errorf("rule set %d: %w", i, err)
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []stream.Rule
+	for i := range t.RuleSets {
+		if t.RuleSets[i].matches(res) {
+			rules = append(rules, t.RuleSets[i].Rules...)
+		}
+	}
+
+	if len(rules) == 0 {
+		return res, nil
+	}
+
+	if err = httpmw.RewriteResponse(res, rules...); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
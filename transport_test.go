@@ -0,0 +1,109 @@
+package rewrite
+
+import (
+	"github.com/html-overwrite/stream"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	const page = `<html><head></head><body><div id="content"></div></body></html>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"page":"<html><head></head></html>"}`))
+		case "/page.xhtml":
+			w.Header().Set("Content-Type", "application/xhtml+xml")
+			_, _ = w.Write([]byte(page))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(page))
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		RuleSets: []RuleSet{
+			{
+				Rules: []stream.Rule{stream.AppendRule("tag=head", "<script>fix()</script>")},
+			},
+			{
+				URL:   regexp.MustCompile(`/blog/`),
+				Rules: []stream.Rule{stream.SetRule("id=content", "<p>blog</p>")},
+			},
+			{
+				URL:          regexp.MustCompile(`\.xhtml$`),
+				ContentTypes: []string{"application/xhtml+xml"},
+				Rules:        []stream.Rule{stream.SetRule("id=content", "<p>xhtml</p>")},
+			},
+		},
+	}}
+
+	get := func(path string) string {
+		res, err := client.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		return string(body)
+	}
+
+	t.Run("every url", func(t *testing.T) {
+		assert.Equal(t,
+			`<html><head><script>fix()</script></head><body><div id="content"></div></body></html>`,
+			get("/index.html"),
+		)
+	})
+
+	t.Run("url pattern", func(t *testing.T) {
+		assert.Equal(t,
+			`<html><head><script>fix()</script></head><body><div id="content"><p>blog</p></div></body></html>`,
+			get("/blog/post.html"),
+		)
+	})
+
+	t.Run("content type", func(t *testing.T) {
+		assert.Equal(t,
+			`<html><head></head><body><div id="content"><p>xhtml</p></div></body></html>`,
+			get("/page.xhtml"),
+		)
+	})
+
+	t.Run("untouched", func(t *testing.T) {
+		assert.Equal(t, `{"page":"<html><head></head></html>"}`, get("/data.json"))
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		requests := 0
+		base := roundTripper(func(req *http.Request) (*http.Response, error) {
+			requests++
+			return http.DefaultTransport.RoundTrip(req)
+		})
+		client := &http.Client{Transport: &Transport{
+			Base: base,
+			RuleSets: []RuleSet{
+				{Rules: []stream.Rule{stream.AppendRule("tag=head", "<script>fix()</script>")}},
+				{URL: regexp.MustCompile(`/blog/`), Rules: []stream.Rule{stream.SetRule("content", "x")}},
+			},
+		}}
+
+		_, err := client.Get(server.URL + "/index.html")
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), `rule set 1: rule 0: invalid path "content"`)
+		}
+		assert.Zero(t, requests)
+	})
+}
+
+// roundTripper adapts a function to http.RoundTripper.
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}